@@ -2,4 +2,10 @@ module latex2image
 
 go 1.21.5
 
-require github.com/gen2brain/go-fitz v1.23.7
+require (
+	github.com/gen2brain/go-fitz v1.23.7
+	go.etcd.io/bbolt v1.3.10
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require golang.org/x/sys v0.4.0 // indirect
@@ -1,26 +1,34 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"latex2image/src"
+	"latex2image/src/download"
+	"latex2image/src/manifest"
+	"latex2image/src/state"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime/debug"
+	"strconv"
 	"strings"
+	"syscall"
 )
 
-var TRAIN_DATASET string = "output/train"
-var TRAIN_COUNT int = 10
-var TEST_DATASET string = "output/test"
-var TEST_COUNT int = 10
-var VALIDATION_DATASET string = "output/validation"
-var VALIDATION_COUNT int = 10
-var IS_DEBUG bool = true
-var IS_REGENERATE bool = true
+const usage = `usage: latex2image <command> [flags]
 
-var isTrainContinue bool = true
-var isTestContinue bool = true
-var isValidationContinue bool = true
+commands:
+  generate   extract arXiv tars and generate table images for all splits
+  render     generate table images from already extracted paper folders
+  validate   check metadata.jsonl of every split against the images on disk
+  stats      print sample counts of every split
+  report     print why tables failed, by class and most frequent cause
+  verify     check local arXiv tars against the manifest
+  download   download the arXiv tars of the manifest from S3
+
+run "latex2image <command> -h" for the flags of a command`
 
 func main() {
 	defer func() {
@@ -31,116 +39,479 @@ func main() {
 		}
 	}()
 
-	rootDir := "/home/yejibing/dataset/arXiv"
-	outDir := "./arXiv"
-	isContinue := readArXivTar(rootDir, outDir)
-	if !isContinue {
-		fmt.Println("read arXiv finished")
+	if len(os.Args) < 2 {
+		fmt.Println(usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "generate":
+		err = runGenerate(os.Args[2:])
+	case "render":
+		err = runRender(os.Args[2:])
+	case "validate":
+		err = runValidate(os.Args[2:])
+	case "stats":
+		err = runStats(os.Args[2:])
+	case "report":
+		err = runReport(os.Args[2:])
+	case "verify":
+		err = runVerify(os.Args[2:])
+	case "download":
+		err = runDownload(os.Args[2:])
+	case "-h", "-help", "--help", "help":
+		fmt.Println(usage)
+	default:
+		fmt.Printf("unknown command %q\n\n%s\n", os.Args[1], usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
 	}
 }
 
-func readArXivTar(source string, output string) bool {
-	yearIndexTars, err := os.ReadDir(source)
+// newFlagSet binds the shared flags to cfg. Flags override values from -config.
+func newFlagSet(name string, cfg *src.Config) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	configPath := fs.String("config", "", "YAML config file, flags given on the command line override it")
+	fs.StringVar(&cfg.SourceDir, "source", cfg.SourceDir, "directory with arXiv_src_*.tar files")
+	fs.StringVar(&cfg.Manifest, "manifest", cfg.Manifest, "arXiv_src_manifest.xml to select and verify tars")
+	fs.StringVar(&cfg.FromYYMM, "from", cfg.FromYYMM, "first yymm of the selected tars, needs -manifest")
+	fs.StringVar(&cfg.ToYYMM, "to", cfg.ToYYMM, "last yymm of the selected tars, needs -manifest")
+	fs.Var((*intListFlag)(&cfg.SeqNums), "seq", "comma separated seq_num of the selected tars, needs -manifest")
+	fs.BoolVar(&cfg.VerifyTars, "verify", cfg.VerifyTars, "check size and md5sum of every tar before reading it, needs -manifest")
+	fs.StringVar(&cfg.WorkDir, "work", cfg.WorkDir, "directory where tars and papers are extracted")
+	fs.StringVar(&cfg.OutputDir, "output", cfg.OutputDir, "directory where the dataset splits are written")
+	fs.StringVar(&cfg.StateFile, "state", cfg.StateFile, "state store used to resume runs, defaults to state.db in the output dir")
+	fs.IntVar(&cfg.TrainCount, "train", cfg.TrainCount, "number of train samples")
+	fs.IntVar(&cfg.TestCount, "test", cfg.TestCount, "number of test samples")
+	fs.IntVar(&cfg.ValidationCount, "validation", cfg.ValidationCount, "number of validation samples")
+	fs.Float64Var(&cfg.TrainRatio, "train-ratio", cfg.TrainRatio, "share of the papers assigned to train, all ratios 0 uses the counts")
+	fs.Float64Var(&cfg.TestRatio, "test-ratio", cfg.TestRatio, "share of the papers assigned to test")
+	fs.Float64Var(&cfg.ValidationRatio, "validation-ratio", cfg.ValidationRatio, "share of the papers assigned to validation")
+	fs.StringVar(&cfg.SplitSeed, "split-seed", cfg.SplitSeed, "seed of the paper to split assignment")
+	fs.Var((*stringListFlag)(&cfg.AllowPackages), "allow-packages", "comma separated packages of the papers loaded by the standalone documents, empty allows all")
+	fs.Var((*stringListFlag)(&cfg.DenyPackages), "deny-packages", "comma separated packages that are never loaded")
+	fs.Var((*stringListFlag)(&cfg.TexInputs), "tex-inputs", "comma separated directories of a paper searched for \\input files, like TEXINPUTS")
+	fs.BoolVar(&cfg.Debug, "debug", cfg.Debug, "keep .tex/.pdf/.log/.aux files next to the images")
+	fs.BoolVar(&cfg.Regenerate, "regenerate", cfg.Regenerate, "process tar folders that are already extracted")
+	fs.Int64Var(&cfg.MaxPaperFileSize, "max-file-size", cfg.MaxPaperFileSize, "maximum decompressed size in bytes of one file of a paper")
+	fs.Int64Var(&cfg.MaxPaperSize, "max-paper-size", cfg.MaxPaperSize, "maximum decompressed size in bytes of one paper")
+	fs.IntVar(&cfg.MaxPaperFiles, "max-paper-files", cfg.MaxPaperFiles, "maximum number of files in one paper")
+	fs.BoolVar(&cfg.Stream, "stream", cfg.Stream, "read papers from the tars in memory instead of extracting them")
+	fs.IntVar(&cfg.ExtractWorkers, "extract-workers", cfg.ExtractWorkers, "number of tars extracted in parallel")
+	fs.IntVar(&cfg.ParseWorkers, "parse-workers", cfg.ParseWorkers, "number of papers parsed in parallel")
+	fs.IntVar(&cfg.CompileWorkers, "compile-workers", cfg.CompileWorkers, "number of tables compiled in parallel")
+	fs.IntVar(&cfg.RenderWorkers, "render-workers", cfg.RenderWorkers, "number of pdfs rendered in parallel")
+	fs.IntVar(&cfg.QueueSize, "queue-size", cfg.QueueSize, "capacity of the queue between two pipeline stages")
+	fs.DurationVar(&cfg.CompileTimeout, "compile-timeout", cfg.CompileTimeout, "pdflatex timeout per table")
+	fs.IntVar(&cfg.CompileAttempts, "compile-attempts", cfg.CompileAttempts, "pdflatex runs per table, failed runs are repaired and retried")
+	fs.IntVar(&cfg.MinImageWidth, "min-width", cfg.MinImageWidth, "minimum image width in pixels")
+	fs.IntVar(&cfg.MinImageHeight, "min-height", cfg.MinImageHeight, "minimum image height in pixels")
+	return fs, configPath
+}
+
+func parseConfig(fs *flag.FlagSet, configPath *string, cfg *src.Config, args []string) error {
+	fs.Parse(args)
+	if *configPath != "" {
+		if err := cfg.LoadConfig(*configPath); err != nil {
+			return err
+		}
+		// parse again so that explicit flags win over the config file
+		fs.Parse(args)
+	}
+	return cfg.Validate()
+}
+
+func runGenerate(args []string) error {
+	cfg := src.DefaultConfig()
+	fs, configPath := newFlagSet("generate", cfg)
+	if err := parseConfig(fs, configPath, cfg, args); err != nil {
+		return err
+	}
+	if cfg.SourceDir == "" {
+		return fmt.Errorf("source dir is empty, set -source or source_dir in the config")
+	}
+
+	var tarPaths []string
+	var m *manifest.Manifest
+	var err error
+	if cfg.Manifest != "" {
+		m, tarPaths, err = selectArXivTars(cfg)
+	} else {
+		tarPaths, err = listArXivTars(cfg.SourceDir)
+	}
 	if err != nil {
-		fmt.Println("Error reading directory:", err)
+		return err
 	}
 
-	for _, yearIndexTar := range yearIndexTars {
-		// yearIndexTar = arXiv_src_2003_026.tar
-		if yearIndexTar.IsDir() || !strings.HasSuffix(yearIndexTar.Name(), ".tar") {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	pipeline, err := src.NewPipeline(cfg, cfg.Splits(), store)
+	if err != nil {
+		return err
+	}
+	if m != nil {
+		pipeline.UseManifest(m)
+	}
+	if err := pipeline.RunTars(ctx, tarPaths); err != nil {
+		return err
+	}
+	for _, split := range cfg.Splits() {
+		fmt.Printf("%s: %d samples\n", split.Name, pipeline.Count(split.Name))
+	}
+	fmt.Printf("papers: %d tar, %d tex, %d pdf-only skipped, %d other skipped\n",
+		pipeline.PayloadCount(src.PayloadTar), pipeline.PayloadCount(src.PayloadTeX),
+		pipeline.PayloadCount(src.PayloadPDF), pipeline.PayloadCount(src.PayloadOther))
+	fmt.Println("read arXiv finished")
+	return nil
+}
+
+func runRender(args []string) error {
+	cfg := src.DefaultConfig()
+	fs, configPath := newFlagSet("render", cfg)
+	splitName := fs.String("split", "train", "split the images are written to")
+	if err := parseConfig(fs, configPath, cfg, args); err != nil {
+		return err
+	}
+	split, ok := cfg.Split(*splitName)
+	if !ok {
+		return fmt.Errorf("unknown split %q", *splitName)
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("no paper folder given")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	store, err := openStore(cfg)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	pipeline, err := src.NewPipeline(cfg, []src.Split{split}, store)
+	if err != nil {
+		return err
+	}
+	if err := pipeline.RunPapers(ctx, fs.Args()); err != nil {
+		return err
+	}
+	fmt.Printf("%s: %d samples\n", split.Name, pipeline.Count(split.Name))
+	return nil
+}
+
+// openStore opens the state store that lets an interrupted run continue where
+// it stopped.
+func openStore(cfg *src.Config) (*state.Store, error) {
+	statePath := cfg.StatePath()
+	if err := os.MkdirAll(filepath.Dir(statePath), 0755); err != nil {
+		return nil, err
+	}
+	return state.Open(statePath)
+}
+
+func runValidate(args []string) error {
+	cfg := src.DefaultConfig()
+	fs, configPath := newFlagSet("validate", cfg)
+	fix := fs.Bool("fix", false, "rewrite metadata.jsonl without the invalid lines")
+	if err := parseConfig(fs, configPath, cfg, args); err != nil {
+		return err
+	}
+
+	for _, split := range cfg.Splits() {
+		valid, invalid, err := src.ValidateDataset(split.Dir, cfg.MinImageWidth, cfg.MinImageHeight, *fix)
+		if err != nil {
+			fmt.Printf("%s: %v\n", split.Name, err)
 			continue
 		}
+		fmt.Printf("%s: %d valid, %d invalid\n", split.Name, valid, invalid)
+	}
+	return nil
+}
+
+func runStats(args []string) error {
+	cfg := src.DefaultConfig()
+	fs, configPath := newFlagSet("stats", cfg)
+	if err := parseConfig(fs, configPath, cfg, args); err != nil {
+		return err
+	}
 
-		// yearIndex = 2003_026
-		yearIndex := src.GetArXivYearIndex(yearIndexTar.Name())
-		if yearIndex == "" {
-			fmt.Println("Error extracting year from filename:", yearIndexTar.Name())
-			return true
+	for _, split := range cfg.Splits() {
+		stats, err := src.GetDatasetStats(split.Dir)
+		if err != nil {
+			fmt.Printf("%s: %v\n", split.Name, err)
+			continue
+		}
+		avgLen := 0
+		if stats.Samples > 0 {
+			avgLen = stats.GroundTruthLen / stats.Samples
 		}
+		fmt.Printf("%s: %d/%d samples, %d missing images, %d invalid lines, avg ground truth length %d\n",
+			split.Name, stats.Samples, split.Count, stats.MissingImages, stats.InvalidLines, avgLen)
+	}
+	return nil
+}
 
-		yearIndexTarPath := filepath.Join(source, yearIndexTar.Name())
-		yearIndexFolder := filepath.Join(output, yearIndex)
+func runReport(args []string) error {
+	cfg := src.DefaultConfig()
+	fs, configPath := newFlagSet("report", cfg)
+	top := fs.Int("top", 20, "number of undefined commands and missing files to list")
+	if err := parseConfig(fs, configPath, cfg, args); err != nil {
+		return err
+	}
+	if *top < 0 {
+		return fmt.Errorf("top must not be negative")
+	}
 
-		if !src.FolderExists(yearIndexFolder) {
-			fmt.Println("file folder not exists and need to decompression")
-			err := src.ExtractTar(yearIndexTarPath, yearIndexFolder)
-			if err != nil {
-				fmt.Printf("decompression error: %v\n", err)
-			} else {
-				fmt.Println("decompression success")
-			}
-		} else {
-			fmt.Printf("folder %s is exists, do not need to be created\n", yearIndex)
-			if !IS_REGENERATE {
-				continue
-			}
-		}
+	store, err := state.Open(cfg.StatePath())
+	if err != nil {
+		return err
+	}
+	defer store.Close()
 
-		// read paper in yearIndexFolder
-		isContinue := readPaperGz(yearIndexFolder)
-		if !isContinue {
-			return false
+	report, err := src.GetFailureReport(store)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("tables: %d rendered, %d failed\n", report.Rendered, report.Failed)
+	fmt.Println("\nfailures by class:")
+	for _, c := range report.ByClass() {
+		fmt.Printf("  %-28s %d\n", c.Name, c.Count)
+	}
+	if papers := report.RejectedPapers(); len(papers) > 0 {
+		fmt.Println("\nrejected papers by class:")
+		for _, c := range papers {
+			fmt.Printf("  %-28s %d\n", c.Name, c.Count)
 		}
 	}
-	return true
+	for _, class := range []string{src.FailUndefined, src.FailMissingFile} {
+		fmt.Printf("\ntop %s:\n", class)
+		for _, c := range report.Top(class, *top) {
+			fmt.Printf("  %-28s %d\n", c.Name, c.Count)
+		}
+	}
+	return nil
 }
 
-func readPaperGz(basePath string) bool {
-	paperGzs, err := os.ReadDir(basePath)
+func runVerify(args []string) error {
+	cfg := src.DefaultConfig()
+	fs, configPath := newFlagSet("verify", cfg)
+	checksum := fs.Bool("checksum", true, "compare md5sum, otherwise only the size")
+	if err := parseConfig(fs, configPath, cfg, args); err != nil {
+		return err
+	}
+	if cfg.Manifest == "" {
+		return fmt.Errorf("manifest is empty, set -manifest or manifest in the config")
+	}
+
+	m, err := manifest.Load(cfg.Manifest)
+	if err != nil {
+		return err
+	}
+	files, err := m.Select(cfg.ManifestSelection())
 	if err != nil {
-		fmt.Println("Error reading directory:", err)
-	}
-	for _, paperGz := range paperGzs {
-		paperGzPath := filepath.Join(basePath, paperGz.Name())
-		paperFolder := filepath.Join(basePath, strings.TrimSuffix(paperGz.Name(), ".gz"))
-
-		if strings.HasSuffix(paperGz.Name(), ".gz") {
-			if !src.FolderExists(paperFolder) {
-				fmt.Println("file folder not exists and need to be created")
-				err := src.ExtractTar(paperGzPath, paperFolder)
-				if err != nil {
-					fmt.Printf("unzip error: %v\n", err)
-				} else {
-					fmt.Println("unzip success")
-				}
-			} else {
-				fmt.Printf("folder %s is exists, do not need to be created\n", paperFolder)
-				continue
-			}
-		}
-
-		// find all .tex files from paper folder
-		paperTexFiles, err := src.FindTexFiles(paperFolder)
+		return err
+	}
+
+	counts := make(map[manifest.Status]int)
+	for _, f := range files {
+		status, err := f.Verify(cfg.SourceDir, *checksum)
 		if err != nil {
-			fmt.Println("Error finding .tex files:", err)
+			fmt.Printf("%s: %v\n", f.Name(), err)
+		}
+		if status != manifest.StatusOK {
+			fmt.Printf("%s: %v\n", f.Name(), status)
+		}
+		counts[status]++
+	}
+	fmt.Printf("%d tars: %d ok, %d missing, %d size mismatch, %d checksum mismatch\n", len(files),
+		counts[manifest.StatusOK], counts[manifest.StatusMissing],
+		counts[manifest.StatusSizeMismatch], counts[manifest.StatusChecksumMismatch])
+	if counts[manifest.StatusOK] != len(files) {
+		return fmt.Errorf("%d tars are missing or corrupt", len(files)-counts[manifest.StatusOK])
+	}
+	return nil
+}
+
+func runDownload(args []string) error {
+	cfg := src.DefaultConfig()
+	fs, configPath := newFlagSet("download", cfg)
+	fs.StringVar(&cfg.S3Endpoint, "endpoint", cfg.S3Endpoint, "S3-compatible endpoint")
+	fs.StringVar(&cfg.S3Region, "region", cfg.S3Region, "S3 region used for signing")
+	fs.StringVar(&cfg.S3Bucket, "bucket", cfg.S3Bucket, "bucket with the src/ tars")
+	fs.BoolVar(&cfg.S3PathStyle, "path-style", cfg.S3PathStyle, "use endpoint/bucket/key urls, needed for MinIO")
+	fs.BoolVar(&cfg.RequesterPays, "requester-pays", cfg.RequesterPays, "send the requester pays header")
+	fs.IntVar(&cfg.DownloadWorkers, "download-workers", cfg.DownloadWorkers, "number of tars downloaded in parallel")
+	fs.IntVar(&cfg.DownloadRetries, "retries", cfg.DownloadRetries, "extra attempts per tar")
+	fs.StringVar(&cfg.ProcessedLog, "log", cfg.ProcessedLog, "file listing the finished tars")
+	if err := parseConfig(fs, configPath, cfg, args); err != nil {
+		return err
+	}
+	if cfg.SourceDir == "" {
+		return fmt.Errorf("source dir is empty, set -source or source_dir in the config")
+	}
+	if cfg.Manifest == "" {
+		return fmt.Errorf("manifest is empty, set -manifest or manifest in the config")
+	}
+
+	accessKey, secretKey := cfg.S3AccessKey, cfg.S3SecretKey
+	if accessKey == "" && secretKey == "" {
+		accessKey, secretKey = os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	client := &download.S3Client{
+		Endpoint:      cfg.S3Endpoint,
+		Region:        cfg.S3Region,
+		Bucket:        cfg.S3Bucket,
+		AccessKey:     accessKey,
+		SecretKey:     secretKey,
+		SessionToken:  os.Getenv("AWS_SESSION_TOKEN"),
+		PathStyle:     cfg.S3PathStyle,
+		RequesterPays: cfg.RequesterPays,
+	}
+	downloader := &download.Downloader{
+		Client:       client,
+		Dir:          cfg.SourceDir,
+		Workers:      cfg.DownloadWorkers,
+		Retries:      cfg.DownloadRetries,
+		ProcessedLog: cfg.ProcessedLog,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if !src.FolderExists(cfg.Manifest) {
+		fmt.Printf("manifest %s not found, downloading it\n", cfg.Manifest)
+		if err := downloader.Download(ctx, "src/arXiv_src_manifest.xml", cfg.Manifest, 0, ""); err != nil {
+			return err
+		}
+	}
+	m, err := manifest.Load(cfg.Manifest)
+	if err != nil {
+		return err
+	}
+	files, err := m.Select(cfg.ManifestSelection())
+	if err != nil {
+		return err
+	}
+
+	failed, skipped := 0, 0
+	for _, result := range downloader.Run(ctx, files) {
+		switch {
+		case result.Err != nil:
+			fmt.Printf("%s: %v\n", result.File.Name(), result.Err)
+			failed++
+		case result.Skipped:
+			skipped++
+		default:
+			fmt.Printf("%s: downloaded\n", result.File.Name())
+		}
+	}
+	fmt.Printf("%d tars: %d downloaded, %d already done, %d failed\n", len(files), len(files)-failed-skipped, skipped, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d downloads failed", failed)
+	}
+	return nil
+}
+
+// selectArXivTars returns the local paths of the tars selected from the
+// manifest. Tars that are not downloaded yet are reported and skipped.
+func selectArXivTars(cfg *src.Config) (*manifest.Manifest, []string, error) {
+	m, err := manifest.Load(cfg.Manifest)
+	if err != nil {
+		return nil, nil, err
+	}
+	files, err := m.Select(cfg.ManifestSelection())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var tarPaths []string
+	for _, f := range files {
+		tarPath := filepath.Join(cfg.SourceDir, f.Name())
+		if !src.FolderExists(tarPath) {
+			fmt.Printf("missing tar %s\n", f.Name())
+			continue
+		}
+		tarPaths = append(tarPaths, tarPath)
+	}
+	fmt.Printf("selected %d tars, %d missing\n", len(files), len(files)-len(tarPaths))
+	return m, tarPaths, nil
+}
+
+func listArXivTars(source string) ([]string, error) {
+	yearIndexTars, err := os.ReadDir(source)
+	if err != nil {
+		return nil, err
+	}
+
+	var tarPaths []string
+	for _, yearIndexTar := range yearIndexTars {
+		// yearIndexTar = arXiv_src_2003_026.tar
+		if yearIndexTar.IsDir() || !strings.HasSuffix(yearIndexTar.Name(), ".tar") {
+			continue
+		}
+		tarPaths = append(tarPaths, filepath.Join(source, yearIndexTar.Name()))
+	}
+	return tarPaths, nil
+}
+
+// intListFlag parses a comma separated list of integers.
+type intListFlag []int
+
+func (f *intListFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	parts := make([]string, len(*f))
+	for i, v := range *f {
+		parts[i] = strconv.Itoa(v)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f *intListFlag) Set(value string) error {
+	*f = nil
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
 			continue
 		}
+		v, err := strconv.Atoi(part)
+		if err != nil {
+			return err
+		}
+		*f = append(*f, v)
+	}
+	return nil
+}
+
+// stringListFlag parses a comma separated list of strings.
+type stringListFlag []string
+
+func (f *stringListFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return strings.Join(*f, ",")
+}
 
-		// get predefined line from main tex file
-		docHead := ""
-		for _, paperTex := range paperTexFiles {
-			latexContent, _ := os.ReadFile(paperTex)
-			tmpDocHead, err := src.ExtractPreamble(string(latexContent), paperFolder)
-			if err != nil {
-				continue
-			}
-			docHead = tmpDocHead
-			break
-		}
-
-		// generate png
-		for _, paperTex := range paperTexFiles {
-			if isTrainContinue {
-				isTrainContinue = src.ProcessTexFile(docHead, paperTex, paperFolder, TRAIN_DATASET, TRAIN_COUNT, IS_DEBUG)
-			} else if isTestContinue {
-				isTestContinue = src.ProcessTexFile(docHead, paperTex, paperFolder, TEST_DATASET, TEST_COUNT, IS_DEBUG)
-			} else if isValidationContinue {
-				isValidationContinue = src.ProcessTexFile(docHead, paperTex, paperFolder, VALIDATION_DATASET, VALIDATION_COUNT, IS_DEBUG)
-			}
-			if !isTrainContinue && !isTestContinue && !isValidationContinue {
-				return false
-			}
-		}
-	}
-	return true
+func (f *stringListFlag) Set(value string) error {
+	*f = nil
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			*f = append(*f, part)
+		}
+	}
+	return nil
 }
@@ -0,0 +1,312 @@
+package src
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MemFS is a read-only in-memory fs.FS holding the files of one paper, so papers
+// can be read straight from the arXiv tar stream without touching the disk.
+type MemFS struct {
+	files map[string][]byte
+	dirs  map[string]map[string]bool
+}
+
+func NewMemFS() *MemFS {
+	return &MemFS{
+		files: make(map[string][]byte),
+		dirs:  map[string]map[string]bool{".": {}},
+	}
+}
+
+// AddFile stores a file and creates its parent directories.
+func (m *MemFS) AddFile(name string, data []byte) error {
+	name = path.Clean(strings.TrimPrefix(name, "/"))
+	if !fs.ValidPath(name) || name == "." {
+		return fmt.Errorf("invalid file name %q", name)
+	}
+	if _, exists := m.dirs[name]; exists {
+		return fmt.Errorf("%s is a directory", name)
+	}
+	m.files[name] = data
+
+	child := name
+	for dir := path.Dir(name); ; dir = path.Dir(dir) {
+		if _, exists := m.dirs[dir]; !exists {
+			m.dirs[dir] = make(map[string]bool)
+		}
+		m.dirs[dir][path.Base(child)] = true
+		if dir == "." {
+			break
+		}
+		child = dir
+	}
+	return nil
+}
+
+func (m *MemFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if data, ok := m.files[name]; ok {
+		return &memFile{info: memFileInfo{name: path.Base(name), size: int64(len(data))}, Reader: bytes.NewReader(data)}, nil
+	}
+	if _, ok := m.dirs[name]; ok {
+		entries, _ := m.ReadDir(name)
+		return &memDir{info: memFileInfo{name: path.Base(name), isDir: true}, entries: entries}, nil
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+func (m *MemFS) ReadFile(name string) ([]byte, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrInvalid}
+	}
+	data, ok := m.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrNotExist}
+	}
+	return bytes.Clone(data), nil
+}
+
+func (m *MemFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	children, ok := m.dirs[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	entries := make([]fs.DirEntry, 0, len(children))
+	for child := range children {
+		childPath := path.Join(name, child)
+		if data, isFile := m.files[childPath]; isFile {
+			entries = append(entries, memFileInfo{name: child, size: int64(len(data))})
+		} else {
+			entries = append(entries, memFileInfo{name: child, isDir: true})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+type memFileInfo struct {
+	name  string
+	size  int64
+	isDir bool
+}
+
+func (i memFileInfo) Name() string       { return i.name }
+func (i memFileInfo) Size() int64        { return i.size }
+func (i memFileInfo) ModTime() time.Time { return time.Time{} }
+func (i memFileInfo) IsDir() bool        { return i.isDir }
+func (i memFileInfo) Sys() any           { return nil }
+func (i memFileInfo) Type() fs.FileMode  { return i.Mode().Type() }
+
+func (i memFileInfo) Info() (fs.FileInfo, error) { return i, nil }
+
+func (i memFileInfo) Mode() fs.FileMode {
+	if i.isDir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+type memFile struct {
+	info memFileInfo
+	*bytes.Reader
+}
+
+func (f *memFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *memFile) Close() error               { return nil }
+
+type memDir struct {
+	info    memFileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *memDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *memDir) Close() error               { return nil }
+
+func (d *memDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: fs.ErrInvalid}
+}
+
+func (d *memDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(rest) {
+		n = len(rest)
+	}
+	d.offset += n
+	return rest[:n], nil
+}
+
+// ReadPaperTar loads a gzipped arXiv submission into memory. Bare TeX becomes
+// main.tex; PDF-only and unknown payloads return a nil MemFS. Rejected entries
+// are reported in the error next to a usable MemFS; when a limit is exceeded
+// the MemFS is nil.
+func ReadPaperTar(r io.Reader, limits ExtractLimits) (*MemFS, PayloadType, error) {
+	gzr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, PayloadOther, err
+	}
+	defer gzr.Close()
+
+	br := bufio.NewReader(gzr)
+	payloadType := DetectPayload(peekPayload(br))
+	switch payloadType {
+	case PayloadTar:
+		memFS, err := readTarToMemFS(tar.NewReader(br), limits)
+		return memFS, payloadType, err
+	case PayloadTeX:
+		var content bytes.Buffer
+		budget := &sizeBudget{limits: limits}
+		if err := budget.copyEntry(&content, br, bareTeXName); err != nil {
+			return nil, payloadType, err
+		}
+		memFS := NewMemFS()
+		memFS.AddFile(bareTeXName, content.Bytes())
+		return memFS, payloadType, nil
+	}
+	return nil, payloadType, nil
+}
+
+// maxLinkHops bounds how many links are followed to find the file behind a link.
+const maxLinkHops = 8
+
+func readTarToMemFS(tr *tar.Reader, limits ExtractLimits) (*MemFS, error) {
+	memFS := NewMemFS()
+	budget := &sizeBudget{limits: limits}
+	// link entry -> target, both relative to the archive root
+	links := make(map[string]string)
+	var rejected []error
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Join(append(rejected, err)...)
+		}
+
+		name, err := safeEntryPath(header.Name, 0)
+		if err != nil {
+			rejected = append(rejected, err)
+			continue
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			continue
+		case tar.TypeReg:
+			var content bytes.Buffer
+			if err := budget.copyEntry(&content, tr, header.Name); err != nil {
+				return nil, errors.Join(append(rejected, err)...)
+			}
+			if err := memFS.AddFile(name, content.Bytes()); err != nil {
+				rejected = append(rejected, &ExtractError{Entry: header.Name, Err: ErrUnsupportedEntry})
+			}
+		case tar.TypeSymlink:
+			linkname := strings.ReplaceAll(header.Linkname, "\\", "/")
+			target := path.Clean(path.Join(path.Dir(name), linkname))
+			if path.IsAbs(linkname) || target == ".." || strings.HasPrefix(target, "../") {
+				rejected = append(rejected, &ExtractError{Entry: header.Name, Err: ErrUnsafeLink})
+				continue
+			}
+			links[name] = target
+		case tar.TypeLink:
+			target, err := safeEntryPath(header.Linkname, 0)
+			if err != nil {
+				rejected = append(rejected, &ExtractError{Entry: header.Name, Err: ErrUnsafeLink})
+				continue
+			}
+			links[name] = target
+		default:
+			rejected = append(rejected, &ExtractError{Entry: header.Name, Err: ErrUnsupportedEntry})
+		}
+	}
+
+	// links become copies of the file they point to, links to directories and
+	// to missing files are dropped
+	for name, target := range links {
+		for hop := 0; hop < maxLinkHops; hop++ {
+			next, isLink := links[target]
+			if !isLink {
+				break
+			}
+			target = next
+		}
+		data, isFile := memFS.files[target]
+		if !isFile {
+			rejected = append(rejected, &ExtractError{Entry: name, Err: ErrUnsupportedEntry})
+			continue
+		}
+		if err := memFS.AddFile(name, data); err != nil {
+			rejected = append(rejected, &ExtractError{Entry: name, Err: ErrUnsupportedEntry})
+		}
+	}
+	return memFS, errors.Join(rejected...)
+}
+
+// StreamArXivTar reads every paper of an arXiv_src_*.tar into memory and passes
+// it to fn. Papers without TeX sources are passed with a nil MemFS. It stops
+// early when fn returns false.
+func StreamArXivTar(tarFile string, limits ExtractLimits, fn func(paperID string, payloadType PayloadType, paperFS *MemFS) bool) error {
+	file, err := os.Open(tarFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	tr := tar.NewReader(file)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// header.Name = 2003/2003.00001.gz
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if strings.HasSuffix(header.Name, ".pdf") {
+			if !fn(strings.TrimSuffix(path.Base(header.Name), ".pdf"), PayloadPDF, nil) {
+				return nil
+			}
+			continue
+		}
+		if !strings.HasSuffix(header.Name, ".gz") {
+			continue
+		}
+		paperID := strings.TrimSuffix(path.Base(header.Name), ".gz")
+
+		paperFS, payloadType, err := ReadPaperTar(tr, limits)
+		if err != nil {
+			fmt.Printf("unzip error %s: %v\n", paperID, err)
+			if paperFS == nil && payloadType != PayloadPDF && payloadType != PayloadOther {
+				continue
+			}
+		}
+		if !fn(paperID, payloadType, paperFS) {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,335 @@
+// Package assemble inlines the files a LaTeX document loads with \input,
+// \include, \subfile and \import, so the document can be read as one source. A
+// source map leads every part of the result back to the file it came from.
+package assemble
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"latex2image/src/latex"
+)
+
+// DefaultMaxDepth bounds how deep files may be nested when Options.MaxDepth is
+// not set.
+const DefaultMaxDepth = 32
+
+var (
+	ErrNotFound = errors.New("file not found")
+	ErrCycle    = errors.New("file includes itself")
+	ErrDepth    = errors.New("files nested too deep")
+	// the name is absolute or leads out of the directory of the paper
+	ErrOutsideRoot = errors.New("file outside of the paper")
+)
+
+type Options struct {
+	// directories searched after the current and the root directory, like
+	// TEXINPUTS
+	SearchPath []string
+	MaxDepth   int
+}
+
+// Span says that Source[Start:End] of the document is the content of File
+// starting at Offset.
+type Span struct {
+	Start  int
+	End    int
+	File   string
+	Offset int
+}
+
+// Location is a position in one of the files of a document. Line and Col start
+// at 1, Col counts runes.
+type Location struct {
+	File   string
+	Offset int
+	Line   int
+	Col    int
+}
+
+func (l Location) String() string {
+	return fmt.Sprintf("%s:%d:%d", l.File, l.Line, l.Col)
+}
+
+type Document struct {
+	Root   string
+	Source string
+	// every file that was inlined, the root first
+	Files []string
+	Spans []Span
+	files map[string]string
+}
+
+// Locate returns the file and position that Source[offset] came from. Offsets
+// that lie between two files, like the line break ending an inlined file, are
+// not found.
+func (d *Document) Locate(offset int) (Location, bool) {
+	i := sort.Search(len(d.Spans), func(i int) bool { return d.Spans[i].End > offset })
+	if i == len(d.Spans) || d.Spans[i].Start > offset {
+		return Location{}, false
+	}
+	span := d.Spans[i]
+	return d.location(span.File, span.Offset+offset-span.Start), true
+}
+
+func (d *Document) location(file string, offset int) Location {
+	before := d.files[file][:offset]
+	return Location{
+		File:   file,
+		Offset: offset,
+		Line:   strings.Count(before, "\n") + 1,
+		Col:    utf8.RuneCountInString(before[strings.LastIndexByte(before, '\n')+1:]) + 1,
+	}
+}
+
+// Assemble reads root from fsys and inlines the files it loads, recursively.
+// File names are looked up like TeX does, relative to the directory of root,
+// and then in the directory of the loading file and in the search path.
+// Files that cannot be found or would include themselves are left as they are
+// and reported in the error; the document is usable anyway. Only a root that
+// cannot be read returns no document.
+func Assemble(fsys fs.FS, root string, opts Options) (*Document, error) {
+	if opts.MaxDepth <= 0 {
+		opts.MaxDepth = DefaultMaxDepth
+	}
+	content, err := fs.ReadFile(fsys, root)
+	if err != nil {
+		return nil, err
+	}
+	a := &assembler{
+		fsys:    fsys,
+		opts:    opts,
+		rootDir: path.Dir(root),
+		doc:     &Document{Root: root, files: make(map[string]string)},
+	}
+	a.doc.files[root] = string(content)
+	a.inline(root, 0, len(content), a.rootDir)
+	a.doc.Source = a.out.String()
+	return a.doc, errors.Join(a.errs...)
+}
+
+type assembler struct {
+	fsys    fs.FS
+	opts    Options
+	rootDir string
+	doc     *Document
+	out     strings.Builder
+	// files being inlined, to find cycles
+	stack []string
+	errs  []error
+}
+
+// inputCommands are the commands that load a file, with the number of
+// arguments before the file name.
+var inputCommands = map[string]int{
+	`\input`:          0,
+	`\include`:        0,
+	`\subfile`:        0,
+	`\import`:         1,
+	`\subimport`:      1,
+	`\inputfrom`:      1,
+	`\includefrom`:    1,
+	`\subinputfrom`:   1,
+	`\subincludefrom`: 1,
+}
+
+// inline writes file[start:end] to the output with the files it loads inlined.
+// dir is the directory names are relative to, which \import changes.
+func (a *assembler) inline(file string, start, end int, dir string) {
+	if !slices.Contains(a.doc.Files, file) {
+		a.doc.Files = append(a.doc.Files, file)
+	}
+	content := a.doc.files[file][start:end]
+	offset := start
+	a.stack = append(a.stack, file)
+	defer func() { a.stack = a.stack[:len(a.stack)-1] }()
+
+	doc, _ := latex.Parse(content)
+	last := 0
+	var visit func(nodes []latex.Node)
+	visit = func(nodes []latex.Node) {
+		for i := 0; i < len(nodes); i++ {
+			n := &nodes[i]
+			if n.Kind != latex.TokenNode {
+				visit(n.Children)
+				continue
+			}
+			if _, ok := inputCommands[n.Token.Text]; !ok || n.Token.Kind != latex.ControlWord {
+				continue
+			}
+			in, ok := a.readCommand(doc, nodes, i, dir)
+			if !ok {
+				continue
+			}
+			a.write(file, content[last:n.Start], offset+last)
+			if !a.load(a.doc.location(file, offset+n.Start), in) {
+				// keep the command
+				a.write(file, content[n.Start:in.end], offset+n.Start)
+			}
+			last = in.end
+			for i+1 < len(nodes) && nodes[i+1].Start < in.end {
+				i++
+			}
+		}
+	}
+	visit(doc.Nodes)
+	a.write(file, content[last:], offset+last)
+}
+
+// input is a file loading command.
+type input struct {
+	command string
+	name    string
+	// directory the name is relative to, also for the files it loads
+	dir string
+	// end of the command in the source
+	end int
+}
+
+func (a *assembler) readCommand(doc *latex.Document, nodes []latex.Node, i int, dir string) (input, bool) {
+	n := &nodes[i]
+	in := input{command: n.Token.Text, dir: dir}
+	next, _ := latex.NextStar(nodes, i+1)
+	if inputCommands[in.command] == 1 {
+		arg, after, ok := latex.NextArg(nodes, next)
+		if !ok || arg.Start == arg.BodyStart {
+			return in, false
+		}
+		importDir := strings.TrimSpace(doc.Uncommented(arg.BodyStart, arg.BodyEnd))
+		if strings.HasPrefix(in.command, `\sub`) {
+			in.dir = path.Join(dir, importDir)
+		} else {
+			in.dir = path.Join(a.rootDir, importDir)
+		}
+		next = after
+	}
+
+	arg, _, ok := latex.NextArg(nodes, next)
+	switch {
+	case ok && arg.Start != arg.BodyStart:
+		in.name = strings.TrimSpace(doc.Uncommented(arg.BodyStart, arg.BodyEnd))
+		in.end = arg.End
+	case in.command == `\input`:
+		// \input file, the name ends at a space or a command
+		start := n.End
+		for start < len(doc.Source) && (doc.Source[start] == ' ' || doc.Source[start] == '\t') {
+			start++
+		}
+		end := start
+		for end < len(doc.Source) && !strings.ContainsRune(" \t\r\n\\{}%", rune(doc.Source[end])) {
+			end++
+		}
+		in.name, in.end = doc.Source[start:end], end
+	default:
+		return in, false
+	}
+	return in, in.name != ""
+}
+
+// load inlines the file of in, which is loaded at pos, and reports whether it
+// did.
+func (a *assembler) load(pos Location, in input) bool {
+	if len(a.stack) > a.opts.MaxDepth {
+		a.errs = append(a.errs, fmt.Errorf("%v: %s{%s}: %w", pos, in.command, in.name, ErrDepth))
+		return false
+	}
+	found, err := a.resolve(in, path.Dir(pos.File))
+	if err != nil {
+		a.errs = append(a.errs, fmt.Errorf("%v: %s{%s}: %w", pos, in.command, in.name, err))
+		return false
+	}
+	if i := slices.Index(a.stack, found); i >= 0 {
+		chain := strings.Join(append(slices.Clone(a.stack[i:]), found), " -> ")
+		a.errs = append(a.errs, fmt.Errorf("%v: %s{%s}: %w: %s", pos, in.command, in.name, ErrCycle, chain))
+		return false
+	}
+	content, err := fs.ReadFile(a.fsys, found)
+	if err != nil {
+		a.errs = append(a.errs, fmt.Errorf("%v: %s{%s}: %w", pos, in.command, in.name, err))
+		return false
+	}
+	text := string(content)
+	a.doc.files[found] = text
+
+	start, end, dir := 0, len(text), in.dir
+	if in.command == `\subfile` {
+		// a subfile is a document of its own, only its body is inlined and
+		// the files it loads are relative to it
+		start, end = documentBody(text)
+		dir = path.Dir(found)
+	}
+	a.inline(found, start, end, dir)
+	// TeX ends a file with a line break
+	if !strings.HasSuffix(text[start:end], "\n") {
+		a.out.WriteByte('\n')
+	}
+	return true
+}
+
+// resolve finds the file of in: relative to the directory of in, the root, the
+// loading file and the search path. Like TeX it tries name.tex before name,
+// \include only adds .tex. Names are confined to the file system of the paper,
+// an absolute name or one only found by leaving it is ErrOutsideRoot, as is
+// every error of the file system other than a missing file.
+func (a *assembler) resolve(in input, fileDir string) (string, error) {
+	if path.IsAbs(in.name) || strings.HasPrefix(in.name, "~") || len(in.name) > 1 && in.name[1] == ':' {
+		return "", ErrOutsideRoot
+	}
+	names := []string{in.name}
+	switch {
+	case in.command == `\include` || in.command == `\includefrom` || in.command == `\subincludefrom`:
+		names = []string{in.name + ".tex"}
+	case path.Ext(in.name) != ".tex":
+		names = []string{in.name + ".tex", in.name}
+	}
+	dirs := append([]string{in.dir, a.rootDir, fileDir}, a.opts.SearchPath...)
+	var outside error
+	for _, dir := range dirs {
+		for _, name := range names {
+			p := path.Join(dir, name)
+			if !fs.ValidPath(p) {
+				outside = ErrOutsideRoot
+				continue
+			}
+			info, err := fs.Stat(a.fsys, p)
+			switch {
+			case err == nil && !info.IsDir():
+				return p, nil
+			case err != nil && !errors.Is(err, fs.ErrNotExist):
+				// e.g. a link leading out of the paper
+				outside = fmt.Errorf("%w: %w", ErrOutsideRoot, err)
+			}
+		}
+	}
+	if outside != nil {
+		return "", outside
+	}
+	return "", ErrNotFound
+}
+
+func (a *assembler) write(file, text string, offset int) {
+	if text == "" {
+		return
+	}
+	start := a.out.Len()
+	a.out.WriteString(text)
+	a.doc.Spans = append(a.doc.Spans, Span{Start: start, End: a.out.Len(), File: file, Offset: offset})
+}
+
+// documentBody returns where the content of the document environment of src
+// starts and ends, or all of src when it has none.
+func documentBody(src string) (int, int) {
+	doc, _ := latex.Parse(src)
+	for i := range doc.Nodes {
+		if n := &doc.Nodes[i]; n.IsEnv("document") {
+			return n.BodyStart, n.BodyEnd
+		}
+	}
+	return 0, len(src)
+}
@@ -0,0 +1,107 @@
+package assemble
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestAssemble(t *testing.T) {
+	files := fstest.MapFS{
+		"intro.tex":          {Data: []byte("intro\n")},
+		"table.tex":          {Data: []byte(`table`)},
+		"sections/a.tex":     {Data: []byte(`A\input{sections/b}`)},
+		"sections/b.tex":     {Data: []byte(`B`)},
+		"chapters/one.tex":   {Data: []byte(`one \input{two}`)},
+		"chapters/two.tex":   {Data: []byte(`two`)},
+		"chapters/sub/three": {Data: []byte(`three`)},
+		"parts/part.tex":     {Data: []byte("\\documentclass[../main]{subfiles}\n\\begin{document}part \\input{note}\\end{document}")},
+		"parts/note.tex":     {Data: []byte(`note`)},
+		"loop.tex":           {Data: []byte(`loop \input{loop}`)},
+		"styles/shared.tex":  {Data: []byte(`shared`)},
+	}
+	tests := []struct {
+		src  string
+		want string
+		err  error
+	}{
+		{`\input{intro}`, "intro\n", nil},
+		{`\input{table}.`, "table\n.", nil},
+		{`\input table \relax`, "table\n \\relax", nil},
+		{`\input{table.tex}`, "table\n", nil},
+		{`\include{intro}`, "intro\n", nil},
+		{`% \input{intro}` + "\nx", "% \\input{intro}\nx", nil},
+		// names are relative to the root, also in nested files
+		{`\input{sections/a}`, "AB\n\n", nil},
+		// \import changes the directory of the files it loads
+		{`\import{chapters/}{one}`, "one two\n\n", nil},
+		{`\import{chapters}{one.tex}`, "one two\n\n", nil},
+		{`\subimport{chapters/}{sub/three}`, "three\n", nil},
+		// only the body of a subfile is inlined, its names are relative to it
+		{`\subfile{parts/part}`, "part note\n\n", nil},
+		{`\input{shared}`, "shared\n", nil},
+		{`\input{missing}`, `\input{missing}`, ErrNotFound},
+		{`\input{loop}`, "loop \\input{loop}\n", ErrCycle},
+		{`\input{../outside}`, `\input{../outside}`, ErrOutsideRoot},
+		{`\input{/etc/passwd}`, `\input{/etc/passwd}`, ErrOutsideRoot},
+	}
+	for _, tt := range tests {
+		files["main.tex"] = &fstest.MapFile{Data: []byte(tt.src)}
+		doc, err := Assemble(files, "main.tex", Options{SearchPath: []string{"styles"}})
+		if tt.err == nil && err != nil || tt.err != nil && !errors.Is(err, tt.err) {
+			t.Errorf("Assemble(%q) error = %v, want %v", tt.src, err, tt.err)
+		}
+		if doc == nil {
+			t.Errorf("Assemble(%q) returned no document", tt.src)
+			continue
+		}
+		if doc.Source != tt.want {
+			t.Errorf("Assemble(%q) = %q, want %q", tt.src, doc.Source, tt.want)
+		}
+	}
+}
+
+func TestAssembleDepth(t *testing.T) {
+	files := fstest.MapFS{
+		"main.tex": {Data: []byte(`\input{a}`)},
+		"a.tex":    {Data: []byte(`a\input{b}`)},
+		"b.tex":    {Data: []byte(`b\input{c}`)},
+		"c.tex":    {Data: []byte(`c`)},
+	}
+	doc, err := Assemble(files, "main.tex", Options{MaxDepth: 2})
+	if !errors.Is(err, ErrDepth) {
+		t.Errorf("error = %v, want %v", err, ErrDepth)
+	}
+	if want := "ab\\input{c}\n\n"; doc.Source != want {
+		t.Errorf("Source = %q, want %q", doc.Source, want)
+	}
+}
+
+func TestLocate(t *testing.T) {
+	files := fstest.MapFS{
+		"main.tex":  {Data: []byte("first\n\\input{sec/a}\nlast")},
+		"sec/a.tex": {Data: []byte("line one\nline two")},
+	}
+	doc, err := Assemble(files, "main.tex", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		text string
+		want Location
+	}{
+		{"first", Location{File: "main.tex", Offset: 0, Line: 1, Col: 1}},
+		{"two", Location{File: "sec/a.tex", Offset: 14, Line: 2, Col: 6}},
+		{"last", Location{File: "main.tex", Offset: 20, Line: 3, Col: 1}},
+	}
+	for _, tt := range tests {
+		got, ok := doc.Locate(strings.Index(doc.Source, tt.text))
+		if !ok || got != tt.want {
+			t.Errorf("Locate(%q) = %v, %v, want %v", tt.text, got, ok, tt.want)
+		}
+	}
+	if want := []string{"main.tex", "sec/a.tex"}; strings.Join(doc.Files, " ") != strings.Join(want, " ") {
+		t.Errorf("Files = %v, want %v", doc.Files, want)
+	}
+}
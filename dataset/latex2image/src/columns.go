@@ -0,0 +1,158 @@
+package src
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"latex2image/src/latex"
+	"latex2image/src/macro"
+)
+
+// columnType is a column type made with \newcolumntype{L}[1]{>{\raggedright}p{#1}}.
+type columnType struct {
+	nargs int
+	body  string
+}
+
+// collectColumnTypes returns the column types defined in src by their letter.
+// Later definitions win like with \renewcolumntype.
+func collectColumnTypes(src string) map[byte]*columnType {
+	doc, _ := latex.Parse(src)
+	types := make(map[byte]*columnType)
+	visitCommands(doc.Nodes, []string{`\newcolumntype`}, func(nodes []latex.Node, i int) int {
+		name, next, ok := latex.NextArg(nodes, i+1)
+		letter := strings.TrimSpace(doc.Uncommented(name.BodyStart, name.BodyEnd))
+		if !ok || len(letter) != 1 {
+			return i
+		}
+		t := &columnType{}
+		if nargs, after, ok := latex.NextOptionalArg(nodes, next); ok {
+			count, err := strconv.Atoi(strings.TrimSpace(doc.Source[nargs.BodyStart:nargs.BodyEnd]))
+			if err != nil || count < 0 || count > 9 {
+				return i
+			}
+			t.nargs = count
+			next = after
+		}
+		body, next, ok := latex.NextArg(nodes, next)
+		if !ok {
+			return i
+		}
+		t.body = doc.Uncommented(body.BodyStart, body.BodyEnd)
+		types[letter[0]] = t
+		return next
+	})
+	return types
+}
+
+// tabularSpec returns the column specification of a tabular environment.
+func tabularSpec(env *latex.Node) (latex.Arg, bool) {
+	nodes := env.Children
+	next := 0
+	switch env.Name {
+	case "tabular*", "tabularx", "tabulary", "supertabular*":
+		// the width comes first
+		_, next, _ = latex.NextArg(nodes, next)
+	default:
+		_, next, _ = latex.NextOptionalArg(nodes, next)
+	}
+	spec, _, ok := latex.NextArg(nodes, next)
+	return spec, ok
+}
+
+// expandColumnTypes replaces the column types of the paper in the
+// specification of the tabular in source by their definitions, so the table
+// neither needs \newcolumntype nor keeps letters only the paper knows.
+func expandColumnTypes(source string, types map[byte]*columnType) (string, error) {
+	if len(types) == 0 {
+		return source, nil
+	}
+	doc, _ := latex.Parse(source)
+	if len(doc.Nodes) == 0 || doc.Nodes[0].Kind != latex.EnvNode {
+		return source, nil
+	}
+	spec, ok := tabularSpec(&doc.Nodes[0])
+	if !ok {
+		return source, nil
+	}
+	expanded, err := expandSpec(source[spec.BodyStart:spec.BodyEnd], types, 0)
+	if err != nil {
+		return "", err
+	}
+	return source[:spec.BodyStart] + expanded + source[spec.BodyEnd:], nil
+}
+
+// expandSpec expands the column types of a column specification. The
+// arguments of p{..}, @{..} and >{..} are kept as they are, the columns
+// repeated by *{n}{..} are expanded.
+func expandSpec(spec string, types map[byte]*columnType, depth int) (string, error) {
+	if depth >= macro.MaxDepth {
+		return "", fmt.Errorf("column types: %w", macro.ErrDepth)
+	}
+	doc, _ := latex.Parse(spec)
+	nodes := doc.Nodes
+	var b strings.Builder
+	for i := 0; i < len(nodes); i++ {
+		n := &nodes[i]
+		if n.Kind != latex.TokenNode || n.Token.Kind != latex.Text && n.Token.Kind != latex.Other {
+			b.WriteString(spec[n.Start:n.End])
+			continue
+		}
+		text := n.Token.Text
+		if text == "*" {
+			count, next, ok := latex.NextArg(nodes, i+1)
+			columns, after, ok2 := latex.NextArg(nodes, next)
+			if !ok || !ok2 {
+				b.WriteString(text)
+				continue
+			}
+			repeated, err := expandSpec(spec[columns.BodyStart:columns.BodyEnd], types, depth+1)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString("*" + spec[count.Start:count.End] + "{" + repeated + "}")
+			i = after - 1
+			continue
+		}
+
+		for j := 0; j < len(text); j++ {
+			t, ok := types[text[j]]
+			if !ok {
+				b.WriteByte(text[j])
+				continue
+			}
+			letter := text[j]
+			var args []string
+			for len(args) < t.nargs {
+				// like TeX an argument is the next letter or group
+				if j+1 < len(text) {
+					j++
+					args = append(args, text[j:j+1])
+					continue
+				}
+				arg, next, ok := latex.NextArg(nodes, i+1)
+				if !ok {
+					return "", fmt.Errorf("column type %c needs %d arguments", letter, t.nargs)
+				}
+				args = append(args, spec[arg.BodyStart:arg.BodyEnd])
+				i = next - 1
+			}
+			expanded, err := expandSpec(fillParams(t.body, args), types, depth+1)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(expanded)
+		}
+	}
+	return b.String(), nil
+}
+
+// fillParams replaces #1 to #9 in body by args.
+func fillParams(body string, args []string) string {
+	var pairs []string
+	for k, arg := range args {
+		pairs = append(pairs, "#"+strconv.Itoa(k+1), arg)
+	}
+	return strings.NewReplacer(pairs...).Replace(body)
+}
@@ -0,0 +1,218 @@
+package src
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"time"
+
+	"latex2image/src/manifest"
+
+	"gopkg.in/yaml.v3"
+)
+
+type Config struct {
+	// directory with arXiv_src_YYMM_NNN.tar files
+	SourceDir string `yaml:"source_dir"`
+	// arXiv_src_manifest.xml, when set the tars are selected from it instead of
+	// listing every tar in SourceDir
+	Manifest string `yaml:"manifest"`
+	// inclusive yymm range and sequence numbers of the selected tars
+	FromYYMM string `yaml:"from_yymm"`
+	ToYYMM   string `yaml:"to_yymm"`
+	SeqNums  []int  `yaml:"seq_nums"`
+	// check size and md5sum of every tar against the manifest before reading it
+	VerifyTars bool `yaml:"verify_tars"`
+
+	// S3-compatible endpoint of the arXiv bucket, a local MinIO works as well
+	S3Endpoint  string `yaml:"s3_endpoint"`
+	S3Region    string `yaml:"s3_region"`
+	S3Bucket    string `yaml:"s3_bucket"`
+	S3PathStyle bool   `yaml:"s3_path_style"`
+	// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are used when empty
+	S3AccessKey     string `yaml:"s3_access_key"`
+	S3SecretKey     string `yaml:"s3_secret_key"`
+	RequesterPays   bool   `yaml:"requester_pays"`
+	DownloadWorkers int    `yaml:"download_workers"`
+	DownloadRetries int    `yaml:"download_retries"`
+	// finished tars, one per line, skipped by the next download
+	ProcessedLog string `yaml:"processed_log"`
+
+	// directory where tars and papers are extracted
+	WorkDir string `yaml:"work_dir"`
+	// directory where train/test/validation splits are written
+	OutputDir string `yaml:"output_dir"`
+
+	TrainCount      int `yaml:"train_count"`
+	TestCount       int `yaml:"test_count"`
+	ValidationCount int `yaml:"validation_count"`
+	// share of the papers that go to each split, all 0 divides them by count
+	TrainRatio      float64 `yaml:"train_ratio"`
+	TestRatio       float64 `yaml:"test_ratio"`
+	ValidationRatio float64 `yaml:"validation_ratio"`
+	// changes which papers end up in which split
+	SplitSeed string `yaml:"split_seed"`
+
+	// state store of the run, defaults to state.db in the output dir
+	StateFile string `yaml:"state_file"`
+
+	// packages of the papers loaded by the standalone documents, an empty
+	// allow list allows every package that is not denied
+	AllowPackages []string `yaml:"allow_packages"`
+	DenyPackages  []string `yaml:"deny_packages"`
+	// directories of a paper searched for \input files after the directory of
+	// the root, like TEXINPUTS
+	TexInputs []string `yaml:"tex_inputs"`
+
+	// keep .tex/.pdf/.log/.aux next to the generated png
+	Debug bool `yaml:"debug"`
+	// process tar folders that were already extracted by a previous run
+	Regenerate bool `yaml:"regenerate"`
+	// read papers straight from the tars instead of extracting them to work dir
+	Stream bool `yaml:"stream"`
+
+	// limits for the decompressed content of one paper, 0 means no limit
+	MaxPaperFileSize int64 `yaml:"max_paper_file_size"`
+	MaxPaperSize     int64 `yaml:"max_paper_size"`
+	MaxPaperFiles    int   `yaml:"max_paper_files"`
+
+	// number of workers of each pipeline stage
+	ExtractWorkers int `yaml:"extract_workers"`
+	ParseWorkers   int `yaml:"parse_workers"`
+	CompileWorkers int `yaml:"compile_workers"`
+	RenderWorkers  int `yaml:"render_workers"`
+	// capacity of the queue between two stages
+	QueueSize int `yaml:"queue_size"`
+
+	CompileTimeout time.Duration `yaml:"compile_timeout"`
+	// pdflatex runs per table, failed runs are repaired from the log and retried
+	CompileAttempts int `yaml:"compile_attempts"`
+	MinImageWidth   int `yaml:"min_image_width"`
+	MinImageHeight  int `yaml:"min_image_height"`
+}
+
+type Split struct {
+	Name  string
+	Dir   string
+	Count int
+	Ratio float64
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		S3Endpoint:       "https://s3.amazonaws.com",
+		S3Region:         "us-east-1",
+		S3Bucket:         "arxiv",
+		RequesterPays:    true,
+		DownloadWorkers:  4,
+		DownloadRetries:  3,
+		ProcessedLog:     "processed.txt",
+		WorkDir:          "./arXiv",
+		OutputDir:        "output",
+		TrainCount:       10,
+		TestCount:        10,
+		ValidationCount:  10,
+		AllowPackages:    slices.Clone(DefaultAllowPackages),
+		DenyPackages:     slices.Clone(DefaultDenyPackages),
+		Debug:            true,
+		Regenerate:       true,
+		MaxPaperFileSize: 64 << 20,
+		MaxPaperSize:     256 << 20,
+		MaxPaperFiles:    10000,
+		ExtractWorkers:   1,
+		ParseWorkers:     2,
+		CompileWorkers:   runtime.NumCPU(),
+		RenderWorkers:    2,
+		QueueSize:        16,
+		CompileTimeout:   5 * time.Second,
+		CompileAttempts:  3,
+		MinImageWidth:    100,
+		MinImageHeight:   100,
+	}
+}
+
+// LoadConfig reads a YAML config file on top of the values already in c.
+func (c *Config) LoadConfig(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := yaml.Unmarshal(content, c); err != nil {
+		return fmt.Errorf("error parsing config %s: %v", path, err)
+	}
+	return nil
+}
+
+func (c *Config) Validate() error {
+	if c.OutputDir == "" {
+		return fmt.Errorf("output dir is empty")
+	}
+	if c.TrainCount < 0 || c.TestCount < 0 || c.ValidationCount < 0 {
+		return fmt.Errorf("split sizes must not be negative")
+	}
+	if c.TrainRatio < 0 || c.TestRatio < 0 || c.ValidationRatio < 0 {
+		return fmt.Errorf("split ratios must not be negative")
+	}
+	if c.ExtractWorkers < 1 || c.ParseWorkers < 1 || c.CompileWorkers < 1 || c.RenderWorkers < 1 {
+		return fmt.Errorf("every stage needs at least one worker")
+	}
+	if c.QueueSize < 0 {
+		return fmt.Errorf("queue size must not be negative")
+	}
+	if c.CompileTimeout <= 0 {
+		return fmt.Errorf("compile timeout must be positive")
+	}
+	if c.CompileAttempts < 1 {
+		return fmt.Errorf("compile attempts must be at least 1")
+	}
+	for _, dir := range c.TexInputs {
+		if !fs.ValidPath(dir) {
+			return fmt.Errorf("tex input %q is not a relative directory inside the paper", dir)
+		}
+	}
+	return nil
+}
+
+func (c *Config) StatePath() string {
+	if c.StateFile != "" {
+		return c.StateFile
+	}
+	return filepath.Join(c.OutputDir, "state.db")
+}
+
+func (c *Config) ManifestSelection() manifest.Selection {
+	return manifest.Selection{
+		FromYYMM: c.FromYYMM,
+		ToYYMM:   c.ToYYMM,
+		SeqNums:  c.SeqNums,
+	}
+}
+
+func (c *Config) PaperLimits() ExtractLimits {
+	return ExtractLimits{
+		MaxFileSize:  c.MaxPaperFileSize,
+		MaxTotalSize: c.MaxPaperSize,
+		MaxFiles:     c.MaxPaperFiles,
+	}
+}
+
+// Splits returns the dataset splits in the order they are filled.
+func (c *Config) Splits() []Split {
+	return []Split{
+		{Name: "train", Dir: filepath.Join(c.OutputDir, "train"), Count: c.TrainCount, Ratio: c.TrainRatio},
+		{Name: "test", Dir: filepath.Join(c.OutputDir, "test"), Count: c.TestCount, Ratio: c.TestRatio},
+		{Name: "validation", Dir: filepath.Join(c.OutputDir, "validation"), Count: c.ValidationCount, Ratio: c.ValidationRatio},
+	}
+}
+
+func (c *Config) Split(name string) (Split, bool) {
+	for _, split := range c.Splits() {
+		if split.Name == name {
+			return split, true
+		}
+	}
+	return Split{}, false
+}
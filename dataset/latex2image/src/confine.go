@@ -0,0 +1,55 @@
+package src
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// confinedFS is an os.DirFS that does not follow links out of its root, so a
+// paper folder that was not extracted by us can not make us read other files.
+type confinedFS struct {
+	root string
+	fsys fs.FS
+}
+
+func newConfinedFS(root string) fs.FS {
+	if resolved, err := filepath.EvalSymlinks(root); err == nil {
+		root = resolved
+	}
+	return &confinedFS{root: root, fsys: os.DirFS(root)}
+}
+
+func (c *confinedFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	resolved, err := filepath.EvalSymlinks(filepath.Join(c.root, filepath.FromSlash(name)))
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if !isInside(c.root, resolved) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: ErrPathTraversal}
+	}
+	return c.fsys.Open(name)
+}
+
+// pdflatexEnv makes kpathsea refuse to read or write files outside of the
+// working directory and its subdirectories, and disables \write18.
+var pdflatexEnv = []string{"openin_any=p", "openout_any=p", "shell_escape=f"}
+
+// pathViolations are printed by kpathsea when it refuses to open a file, like
+// "pdflatex: Not reading from /etc/passwd (openin_any = p)."
+var pathViolations = []string{"(openin_any = p)", "(openout_any = p)"}
+
+// violatingPath returns the file of a kpathsea refusal.
+func violatingPath(line string) string {
+	for _, prefix := range []string{"Not reading from ", "Not writing to "} {
+		if _, rest, ok := strings.Cut(line, prefix); ok {
+			name, _, _ := strings.Cut(rest, " (")
+			return name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,83 @@
+package src
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfinedFS(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "paper")
+	for name, content := range map[string]string{
+		"secret.tex":           "secret",
+		"paper/main.tex":       "main",
+		"paper/sections/a.tex": "a",
+	} {
+		file := filepath.Join(base, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"inside.tex":  "sections/a.tex",
+		"outside.tex": "../secret.tex",
+		"absolute":    filepath.Join(base, "secret.tex"),
+		"up":          "..",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		want string
+		err  error
+	}{
+		{name: "main.tex", want: "main"},
+		{name: "sections/a.tex", want: "a"},
+		{name: "inside.tex", want: "a"},
+		{name: "outside.tex", err: ErrPathTraversal},
+		{name: "absolute", err: ErrPathTraversal},
+		{name: "up/secret.tex", err: ErrPathTraversal},
+		{name: "../secret.tex", err: fs.ErrInvalid},
+		{name: "/secret.tex", err: fs.ErrInvalid},
+		{name: "missing.tex", err: fs.ErrNotExist},
+	}
+	fsys := newConfinedFS(root)
+	for _, tt := range tests {
+		content, err := fs.ReadFile(fsys, tt.name)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("ReadFile(%q) error = %v, want %v", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || string(content) != tt.want {
+			t.Errorf("ReadFile(%q) = %q, %v, want %q", tt.name, content, err, tt.want)
+		}
+	}
+}
+
+func TestViolatingPath(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"pdflatex: Not reading from /etc/passwd (openin_any = p).", "/etc/passwd"},
+		{"pdflatex: Not writing to ../out.tex (openout_any = p).", "../out.tex"},
+		{"! I can't find file `x'.", ""},
+	}
+	for _, tt := range tests {
+		if got := violatingPath(tt.line); got != tt.want {
+			t.Errorf("violatingPath(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package src
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type DatasetStats struct {
+	Samples        int
+	MissingImages  int
+	InvalidLines   int
+	GroundTruthLen int
+}
+
+func readMetadata(splitDir string, fn func(line string, metadata *Metadata)) error {
+	file, err := os.Open(filepath.Join(splitDir, "metadata.jsonl"))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		var metadata Metadata
+		if err := json.Unmarshal([]byte(line), &metadata); err != nil {
+			fn(line, nil)
+			continue
+		}
+		fn(line, &metadata)
+	}
+	return scanner.Err()
+}
+
+// ValidateDataset checks that every metadata.jsonl line of a split points to an
+// existing png of at least the minimum size. With fix, invalid lines are dropped.
+func ValidateDataset(splitDir string, minWidth, minHeight int, fix bool) (int, int, error) {
+	var validLines []string
+	invalid := 0
+	err := readMetadata(splitDir, func(line string, metadata *Metadata) {
+		if metadata == nil {
+			fmt.Printf("not valid JSON line: %s\n", line)
+			invalid++
+			return
+		}
+		if metadata.FileName == "" {
+			fmt.Println("file_name field miss")
+			invalid++
+			return
+		}
+		if err := checkImage(filepath.Join(splitDir, metadata.FileName), minWidth, minHeight); err != nil {
+			fmt.Printf("invalid image %s: %v\n", metadata.FileName, err)
+			invalid++
+			return
+		}
+		validLines = append(validLines, line)
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if fix && invalid > 0 {
+		content := strings.Join(validLines, "\n")
+		if len(validLines) > 0 {
+			content += "\n"
+		}
+		if err := os.WriteFile(filepath.Join(splitDir, "metadata.jsonl"), []byte(content), 0644); err != nil {
+			return 0, 0, err
+		}
+	}
+	return len(validLines), invalid, nil
+}
+
+func checkImage(imageFile string, minWidth, minHeight int) error {
+	f, err := os.Open(imageFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	imgConfig, err := png.DecodeConfig(f)
+	if err != nil {
+		return err
+	}
+	if imgConfig.Width < minWidth || imgConfig.Height < minHeight {
+		return fmt.Errorf("image size too small: %dx%d (minimum required: %dx%d)", imgConfig.Width, imgConfig.Height, minWidth, minHeight)
+	}
+	return nil
+}
+
+func GetDatasetStats(splitDir string) (DatasetStats, error) {
+	var stats DatasetStats
+	err := readMetadata(splitDir, func(line string, metadata *Metadata) {
+		if metadata == nil {
+			stats.InvalidLines++
+			return
+		}
+		stats.Samples++
+		stats.GroundTruthLen += len(metadata.GroundTruth)
+		if !FolderExists(filepath.Join(splitDir, metadata.FileName)) {
+			stats.MissingImages++
+		}
+	})
+	return stats, err
+}
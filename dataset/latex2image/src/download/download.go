@@ -0,0 +1,227 @@
+package download
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"latex2image/src/manifest"
+)
+
+// Downloader fetches the tars of a manifest into Dir with a pool of workers.
+// Interrupted downloads are kept as .part files and resumed with range requests.
+type Downloader struct {
+	Client  *S3Client
+	Dir     string
+	Workers int
+	// Retries is the number of extra attempts per tar.
+	Retries int
+	// ProcessedLog lists finished tars, one name per line, like processed.txt of
+	// download.py. Tars in it are skipped.
+	ProcessedLog string
+
+	logMu sync.Mutex
+}
+
+type Result struct {
+	File manifest.File
+	Err  error
+	// Skipped is set for tars that were already in the processed log.
+	Skipped bool
+}
+
+// Run downloads files and returns one result per file.
+func (d *Downloader) Run(ctx context.Context, files []manifest.File) []Result {
+	if err := os.MkdirAll(d.Dir, 0755); err != nil {
+		return []Result{{Err: err}}
+	}
+	processed, err := d.readProcessedLog()
+	if err != nil {
+		return []Result{{Err: err}}
+	}
+
+	workers := d.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	results := make([]Result, len(files))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range jobs {
+				f := files[index]
+				if processed[f.Name()] {
+					results[index] = Result{File: f, Skipped: true}
+					continue
+				}
+				err := d.downloadWithRetry(ctx, f)
+				if err == nil {
+					err = d.appendProcessedLog(f.Name())
+				}
+				results[index] = Result{File: f, Err: err}
+			}
+		}()
+	}
+
+feed:
+	for index := range files {
+		select {
+		case jobs <- index:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	// files that were never started because of cancellation
+	for index := range results {
+		if results[index].File.Filename == "" {
+			results[index] = Result{File: files[index], Err: ctx.Err()}
+		}
+	}
+	return results
+}
+
+func (d *Downloader) downloadWithRetry(ctx context.Context, f manifest.File) error {
+	var err error
+	for attempt := 0; attempt <= d.Retries; attempt++ {
+		if attempt > 0 {
+			fmt.Printf("retry %s (%d/%d): %v\n", f.Name(), attempt, d.Retries, err)
+			select {
+			case <-time.After(time.Duration(attempt) * 2 * time.Second):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		err = d.Download(ctx, f.Filename, filepath.Join(d.Dir, f.Name()), f.Size, f.MD5)
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// Download fetches key into dest. It resumes from dest.part, and only renames
+// the part file to dest once size and md5sum match. Empty size or md5sum skip
+// the corresponding check.
+func (d *Downloader) Download(ctx context.Context, key string, dest string, size int64, md5sum string) error {
+	partFile := dest + ".part"
+	var offset int64
+	if info, err := os.Stat(partFile); err == nil {
+		offset = info.Size()
+	}
+	if size > 0 && offset > size {
+		os.Remove(partFile)
+		offset = 0
+	}
+
+	if size <= 0 || offset < size {
+		if err := d.fetch(ctx, key, partFile, offset); err != nil {
+			return err
+		}
+	}
+
+	if size > 0 {
+		info, err := os.Stat(partFile)
+		if err != nil {
+			return err
+		}
+		if info.Size() != size {
+			return fmt.Errorf("%s: got %d bytes, expected %d", key, info.Size(), size)
+		}
+	}
+	if md5sum != "" {
+		sum, err := manifest.MD5File(partFile)
+		if err != nil {
+			return err
+		}
+		if sum != md5sum {
+			// the part file is corrupt, start over on the next attempt
+			os.Remove(partFile)
+			return fmt.Errorf("%s: md5sum %s, expected %s", key, sum, md5sum)
+		}
+	}
+	return os.Rename(partFile, dest)
+}
+
+func (d *Downloader) fetch(ctx context.Context, key string, partFile string, offset int64) error {
+	resp, err := d.Client.Get(ctx, key, offset)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		flags |= os.O_APPEND
+	case http.StatusRequestedRangeNotSatisfiable:
+		// the part file already holds the whole object
+		return nil
+	default:
+		// the server ignored the range, write from the beginning
+		flags |= os.O_TRUNC
+	}
+
+	f, err := os.OpenFile(partFile, flags, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, resp.Body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (d *Downloader) readProcessedLog() (map[string]bool, error) {
+	processed := make(map[string]bool)
+	if d.ProcessedLog == "" {
+		return processed, nil
+	}
+	file, err := os.Open(d.ProcessedLog)
+	if os.IsNotExist(err) {
+		return processed, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		name := filepath.Base(strings.TrimSpace(scanner.Text()))
+		if name != "" && name != "." {
+			processed[name] = true
+		}
+	}
+	return processed, scanner.Err()
+}
+
+func (d *Downloader) appendProcessedLog(name string) error {
+	if d.ProcessedLog == "" {
+		return nil
+	}
+	d.logMu.Lock()
+	defer d.logMu.Unlock()
+
+	file, err := os.OpenFile(d.ProcessedLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.WriteString(name + "\n")
+	return err
+}
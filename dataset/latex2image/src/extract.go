@@ -0,0 +1,381 @@
+package src
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ErrPathTraversal    = errors.New("entry escapes the destination directory")
+	ErrUnsafeLink       = errors.New("link target escapes the destination directory")
+	ErrFileTooLarge     = errors.New("file exceeds the size limit")
+	ErrArchiveTooLarge  = errors.New("archive exceeds the size limit")
+	ErrTooManyFiles     = errors.New("archive exceeds the file count limit")
+	ErrUnsupportedEntry = errors.New("unsupported entry type")
+)
+
+// ExtractError is returned for every archive entry the extractor rejects.
+// Err is one of the Err* values above, so callers can use errors.Is.
+type ExtractError struct {
+	Entry string
+	Err   error
+}
+
+func (e *ExtractError) Error() string {
+	return fmt.Sprintf("rejected %q: %v", e.Entry, e.Err)
+}
+
+func (e *ExtractError) Unwrap() error {
+	return e.Err
+}
+
+// ExtractLimits caps the decompressed size of an archive. Zero means no limit.
+type ExtractLimits struct {
+	MaxFileSize  int64
+	MaxTotalSize int64
+	MaxFiles     int
+}
+
+// sizeBudget tracks the bytes and files already taken from an archive.
+type sizeBudget struct {
+	limits ExtractLimits
+	total  int64
+	files  int
+}
+
+// copyEntry copies one entry and fails as soon as a limit is exceeded, without
+// trusting the size in the tar header.
+func (b *sizeBudget) copyEntry(dst io.Writer, src io.Reader, name string) error {
+	b.files++
+	if b.limits.MaxFiles > 0 && b.files > b.limits.MaxFiles {
+		return &ExtractError{Entry: name, Err: ErrTooManyFiles}
+	}
+
+	limit := int64(-1)
+	if b.limits.MaxFileSize > 0 {
+		limit = b.limits.MaxFileSize
+	}
+	if b.limits.MaxTotalSize > 0 && (limit < 0 || b.limits.MaxTotalSize-b.total < limit) {
+		limit = b.limits.MaxTotalSize - b.total
+	}
+
+	var n int64
+	var err error
+	if limit < 0 {
+		n, err = io.Copy(dst, src)
+	} else {
+		n, err = io.CopyN(dst, src, limit+1)
+		if err == io.EOF {
+			err = nil
+		}
+	}
+	b.total += n
+	if err != nil {
+		return err
+	}
+	if b.limits.MaxFileSize > 0 && n > b.limits.MaxFileSize {
+		return &ExtractError{Entry: name, Err: ErrFileTooLarge}
+	}
+	if b.limits.MaxTotalSize > 0 && b.total > b.limits.MaxTotalSize {
+		return &ExtractError{Entry: name, Err: ErrArchiveTooLarge}
+	}
+	return nil
+}
+
+// safeEntryPath cleans an entry name and returns it relative to the archive root.
+// Absolute names and names that leave the root are rejected.
+func safeEntryPath(name string, stripComponents int) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if path.IsAbs(name) || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", &ExtractError{Entry: name, Err: ErrPathTraversal}
+	}
+	cleaned := path.Clean(name)
+	if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", &ExtractError{Entry: name, Err: ErrPathTraversal}
+	}
+
+	parts := strings.Split(cleaned, "/")
+	if stripComponents > 0 {
+		if len(parts) <= stripComponents {
+			return ".", nil
+		}
+		parts = parts[stripComponents:]
+	}
+	return path.Join(parts...), nil
+}
+
+// IsLimitError reports whether extraction was aborted by a size or file count
+// limit, which means the archive is incomplete and should be skipped.
+func IsLimitError(err error) bool {
+	return errors.Is(err, ErrFileTooLarge) || errors.Is(err, ErrArchiveTooLarge) || errors.Is(err, ErrTooManyFiles)
+}
+
+// ExtractTar extracts an arXiv_src_*.tar (or a .tar.gz) into destDir, dropping
+// the leading yymm folder of every entry.
+func ExtractTar(tarFile, destDir string, limits ExtractLimits) error {
+	file, err := os.Open(tarFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var tr *tar.Reader
+	var gzr *gzip.Reader
+
+	if strings.HasSuffix(tarFile, ".gz") || strings.HasSuffix(tarFile, ".tgz") {
+		gzr, err = gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer gzr.Close()
+
+		tr = tar.NewReader(gzr)
+	} else {
+		tr = tar.NewReader(file)
+	}
+
+	return extractTarReader(tr, destDir, limits, 1)
+}
+
+// ExtractPaperGz unpacks one gzipped arXiv submission. Tar payloads are extracted
+// into destDir and bare TeX is written as destDir/main.tex. PDF-only and unknown
+// payloads are not extracted; the detected type is returned for every payload.
+func ExtractPaperGz(gzFile, destDir string, limits ExtractLimits) (PayloadType, error) {
+	file, err := os.Open(gzFile)
+	if err != nil {
+		return PayloadOther, err
+	}
+	defer file.Close()
+
+	gzr, err := gzip.NewReader(file)
+	if err != nil {
+		return PayloadOther, err
+	}
+	defer gzr.Close()
+
+	br := bufio.NewReader(gzr)
+	payloadType := DetectPayload(peekPayload(br))
+	switch payloadType {
+	case PayloadTar:
+		return payloadType, extractTarReader(tar.NewReader(br), destDir, limits, 0)
+	case PayloadTeX:
+		if err := os.MkdirAll(destDir, 0755); err != nil {
+			return payloadType, err
+		}
+		f, err := os.OpenFile(filepath.Join(destDir, bareTeXName), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+		if err != nil {
+			return payloadType, err
+		}
+		defer f.Close()
+		budget := &sizeBudget{limits: limits}
+		return payloadType, budget.copyEntry(f, br, bareTeXName)
+	}
+	return payloadType, nil
+}
+
+// extractTarReader extracts every entry that stays inside destDir. Entries that
+// escape it are skipped and reported; exceeding a limit aborts extraction.
+// All rejections are returned joined.
+func extractTarReader(tr *tar.Reader, destDir string, limits ExtractLimits, stripComponents int) error {
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return err
+	}
+	root, err := filepath.EvalSymlinks(destDir)
+	if err != nil {
+		return err
+	}
+	root, err = filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+
+	budget := &sizeBudget{limits: limits}
+	var rejected []error
+	abort := func(err error) error {
+		return errors.Join(append(rejected, err)...)
+	}
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return abort(err)
+		}
+
+		name, err := safeEntryPath(header.Name, stripComponents)
+		if err != nil {
+			rejected = append(rejected, err)
+			continue
+		}
+		if name == "." {
+			continue
+		}
+		target := filepath.Join(root, filepath.FromSlash(name))
+
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeDir &&
+			header.Typeflag != tar.TypeSymlink && header.Typeflag != tar.TypeLink {
+			rejected = append(rejected, &ExtractError{Entry: header.Name, Err: ErrUnsupportedEntry})
+			continue
+		}
+
+		parent, err := makeParentInside(root, target, header.Name)
+		if err != nil {
+			rejected = append(rejected, err)
+			continue
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if info, err := os.Lstat(target); err == nil && !info.IsDir() {
+				rejected = append(rejected, &ExtractError{Entry: header.Name, Err: ErrUnsupportedEntry})
+				continue
+			}
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return abort(err)
+			}
+		case tar.TypeReg:
+			if err := writeEntry(target, tr, budget, header.Name); err != nil {
+				var extractErr *ExtractError
+				if IsLimitError(err) || !errors.As(err, &extractErr) {
+					return abort(err)
+				}
+				rejected = append(rejected, err)
+			}
+		case tar.TypeSymlink:
+			// resolve the target from the real parent directory, and store the
+			// cleaned relative form so ".." can not walk through other links
+			linkname := filepath.FromSlash(strings.ReplaceAll(header.Linkname, "\\", "/"))
+			if filepath.IsAbs(linkname) || filepath.VolumeName(linkname) != "" {
+				rejected = append(rejected, &ExtractError{Entry: header.Name, Err: ErrUnsafeLink})
+				continue
+			}
+			resolved := filepath.Join(parent, linkname)
+			if !isInside(root, resolved) {
+				rejected = append(rejected, &ExtractError{Entry: header.Name, Err: ErrUnsafeLink})
+				continue
+			}
+			relTarget, err := filepath.Rel(parent, resolved)
+			if err != nil {
+				rejected = append(rejected, &ExtractError{Entry: header.Name, Err: ErrUnsafeLink})
+				continue
+			}
+			if err := removeNonDir(target, header.Name); err != nil {
+				rejected = append(rejected, err)
+				continue
+			}
+			if err := os.Symlink(relTarget, target); err != nil {
+				return abort(err)
+			}
+		case tar.TypeLink:
+			// hard link names are relative to the archive root
+			linkName, err := safeEntryPath(header.Linkname, stripComponents)
+			if err != nil || linkName == "." {
+				rejected = append(rejected, &ExtractError{Entry: header.Name, Err: ErrUnsafeLink})
+				continue
+			}
+			source, err := filepath.EvalSymlinks(filepath.Join(root, filepath.FromSlash(linkName)))
+			if err != nil || !isInside(root, source) {
+				rejected = append(rejected, &ExtractError{Entry: header.Name, Err: ErrUnsafeLink})
+				continue
+			}
+			if info, err := os.Stat(source); err != nil || !info.Mode().IsRegular() {
+				rejected = append(rejected, &ExtractError{Entry: header.Name, Err: ErrUnsupportedEntry})
+				continue
+			}
+			if err := removeNonDir(target, header.Name); err != nil {
+				rejected = append(rejected, err)
+				continue
+			}
+			if err := os.Link(source, target); err != nil {
+				return abort(err)
+			}
+		}
+	}
+
+	return errors.Join(rejected...)
+}
+
+// makeParentInside creates the directory that will hold target and returns its
+// real path. The deepest existing ancestor is resolved before anything is
+// created, so a symlink from an earlier entry can not lead outside root.
+func makeParentInside(root string, target string, entry string) (string, error) {
+	parent := filepath.Dir(target)
+	existing := parent
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		if existing == root || existing == filepath.Dir(existing) {
+			break
+		}
+		existing = filepath.Dir(existing)
+	}
+	resolved, err := filepath.EvalSymlinks(existing)
+	if err != nil || !isInside(root, resolved) {
+		return "", &ExtractError{Entry: entry, Err: ErrPathTraversal}
+	}
+
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return "", &ExtractError{Entry: entry, Err: ErrUnsupportedEntry}
+	}
+	resolved, err = filepath.EvalSymlinks(parent)
+	if err != nil || !isInside(root, resolved) {
+		return "", &ExtractError{Entry: entry, Err: ErrPathTraversal}
+	}
+	return resolved, nil
+}
+
+func isInside(root string, target string) bool {
+	rel, err := filepath.Rel(root, target)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
+// removeNonDir clears an existing file or link before a link is created in its
+// place. Directories are never replaced.
+func removeNonDir(target string, entry string) error {
+	info, err := os.Lstat(target)
+	if err != nil {
+		return nil
+	}
+	if info.IsDir() {
+		return &ExtractError{Entry: entry, Err: ErrUnsupportedEntry}
+	}
+	return os.Remove(target)
+}
+
+func writeEntry(target string, r io.Reader, budget *sizeBudget, entry string) error {
+	// never write through a symlink created by an earlier entry
+	if info, err := os.Lstat(target); err == nil {
+		if info.Mode()&os.ModeSymlink != 0 {
+			return &ExtractError{Entry: entry, Err: ErrPathTraversal}
+		}
+		if !info.Mode().IsRegular() {
+			return &ExtractError{Entry: entry, Err: ErrUnsupportedEntry}
+		}
+	}
+
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	err = budget.copyEntry(f, r, entry)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(target)
+	}
+	return err
+}
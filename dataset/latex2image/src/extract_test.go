@@ -0,0 +1,186 @@
+package src
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSafeEntryPath(t *testing.T) {
+	tests := []struct {
+		name  string
+		strip int
+		want  string
+		err   error
+	}{
+		{name: "paper/main.tex", want: "paper/main.tex"},
+		{name: "./paper//main.tex", want: "paper/main.tex"},
+		{name: "a/../main.tex", want: "main.tex"},
+		{name: "2001/2001.00001.gz", strip: 1, want: "2001.00001.gz"},
+		{name: "2001", strip: 1, want: "."},
+		{name: "../evil.tex", err: ErrPathTraversal},
+		{name: "..", err: ErrPathTraversal},
+		{name: "a/../../evil.tex", err: ErrPathTraversal},
+		{name: `..\evil.tex`, err: ErrPathTraversal},
+		{name: "/etc/passwd", err: ErrPathTraversal},
+		{name: `\etc\passwd`, err: ErrPathTraversal},
+	}
+	for _, tt := range tests {
+		got, err := safeEntryPath(tt.name, tt.strip)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("safeEntryPath(%q) error = %v, want %v", tt.name, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("safeEntryPath(%q) = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+// entry is a tar entry of a test archive.
+type entry struct {
+	name     string
+	typeflag byte
+	linkname string
+	content  string
+}
+
+func buildTar(t *testing.T, entries []entry) *tar.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		header := &tar.Header{Name: e.name, Typeflag: e.typeflag, Linkname: e.linkname, Mode: 0644, Size: int64(len(e.content))}
+		if e.typeflag == tar.TypeDir {
+			header.Mode = 0755
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if e.typeflag == tar.TypeReg {
+			if _, err := tw.Write([]byte(e.content)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return tar.NewReader(&buf)
+}
+
+func TestExtractTarReaderRejects(t *testing.T) {
+	tests := []struct {
+		desc    string
+		entries []entry
+		err     error
+		// file that must not exist, relative to the directory around dest
+		outside string
+	}{
+		{
+			desc:    "parent directory",
+			entries: []entry{{name: "../evil.tex", typeflag: tar.TypeReg, content: "x"}},
+			err:     ErrPathTraversal,
+			outside: "evil.tex",
+		},
+		{
+			desc:    "parent directory in the middle",
+			entries: []entry{{name: "a/../../evil.tex", typeflag: tar.TypeReg, content: "x"}},
+			err:     ErrPathTraversal,
+			outside: "evil.tex",
+		},
+		{
+			desc:    "absolute path",
+			entries: []entry{{name: "/evil.tex", typeflag: tar.TypeReg, content: "x"}},
+			err:     ErrPathTraversal,
+		},
+		{
+			desc:    "symlink to the parent",
+			entries: []entry{{name: "up", typeflag: tar.TypeSymlink, linkname: ".."}},
+			err:     ErrUnsafeLink,
+			outside: "dest/up",
+		},
+		{
+			desc:    "symlink leaving through a subdirectory",
+			entries: []entry{{name: "a/b/link", typeflag: tar.TypeSymlink, linkname: "../../../secret"}},
+			err:     ErrUnsafeLink,
+		},
+		{
+			desc:    "absolute symlink",
+			entries: []entry{{name: "etc", typeflag: tar.TypeSymlink, linkname: "/etc"}},
+			err:     ErrUnsafeLink,
+			outside: "dest/etc",
+		},
+		{
+			desc:    "hard link to the parent",
+			entries: []entry{{name: "passwd", typeflag: tar.TypeLink, linkname: "../secret"}},
+			err:     ErrUnsafeLink,
+			outside: "dest/passwd",
+		},
+		{
+			desc:    "absolute hard link",
+			entries: []entry{{name: "passwd", typeflag: tar.TypeLink, linkname: "/etc/passwd"}},
+			err:     ErrUnsafeLink,
+			outside: "dest/passwd",
+		},
+		{
+			desc: "write through an earlier symlink",
+			entries: []entry{
+				{name: "sub", typeflag: tar.TypeDir},
+				{name: "link", typeflag: tar.TypeSymlink, linkname: "sub/target.tex"},
+				{name: "link", typeflag: tar.TypeReg, content: "x"},
+			},
+			err: ErrPathTraversal,
+		},
+		{
+			desc:    "device",
+			entries: []entry{{name: "null", typeflag: tar.TypeChar}},
+			err:     ErrUnsupportedEntry,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			base := t.TempDir()
+			// a file outside of dest that links could reach
+			if err := os.WriteFile(filepath.Join(base, "secret"), []byte("secret"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			dest := filepath.Join(base, "dest")
+			err := extractTarReader(buildTar(t, tt.entries), dest, ExtractLimits{}, 0)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error = %v, want %v", err, tt.err)
+			}
+			if tt.outside != "" {
+				if _, err := os.Lstat(filepath.Join(base, tt.outside)); err == nil {
+					t.Errorf("%s was created", tt.outside)
+				}
+			}
+			if content, err := os.ReadFile(filepath.Join(base, "secret")); err != nil || string(content) != "secret" {
+				t.Errorf("file outside of dest changed: %q, %v", content, err)
+			}
+		})
+	}
+}
+
+func TestExtractTarReaderKeepsLinksInside(t *testing.T) {
+	dest := t.TempDir()
+	entries := []entry{
+		{name: "sections/results.tex", typeflag: tar.TypeReg, content: "tables"},
+		{name: "results.tex", typeflag: tar.TypeSymlink, linkname: "sections/results.tex"},
+		{name: "sections/copy.tex", typeflag: tar.TypeLink, linkname: "sections/results.tex"},
+	}
+	if err := extractTarReader(buildTar(t, entries), dest, ExtractLimits{}, 0); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"sections/results.tex", "results.tex", "sections/copy.tex"} {
+		content, err := os.ReadFile(filepath.Join(dest, name))
+		if err != nil || string(content) != "tables" {
+			t.Errorf("%s = %q, %v", name, content, err)
+		}
+	}
+}
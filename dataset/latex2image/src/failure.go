@@ -0,0 +1,146 @@
+package src
+
+import (
+	"cmp"
+	"errors"
+	"slices"
+
+	"latex2image/src/state"
+)
+
+// Classes of tables that did not become a sample.
+const (
+	FailTimeout       = "timeout"
+	FailUndefined     = "undefined control sequence"
+	FailMissingFile   = "missing file"
+	FailMissingDollar = "missing $"
+	FailMisplacedTab  = "misplaced alignment tab"
+	FailRunaway       = "runaway argument"
+	FailLaTeX         = "latex error"
+	FailNoPDF         = "no pdf"
+	FailMultiPage     = "multi-page pdf"
+	FailTooSmall      = "image too small"
+	FailRender        = "render error"
+	FailMacro         = "macro expansion"
+	FailPathViolation = "path violation"
+	FailOther         = "other"
+)
+
+// Failure is a classified error of a table. Name is the undefined command or
+// the missing file, if the class has one.
+type Failure struct {
+	Class string
+	Name  string
+	Err   error
+}
+
+func (f *Failure) Error() string {
+	msg := f.Class
+	if f.Name != "" {
+		msg += " " + f.Name
+	}
+	if f.Err != nil {
+		msg += ": " + f.Err.Error()
+	}
+	return msg
+}
+
+func (f *Failure) Unwrap() error {
+	return f.Err
+}
+
+// classifyFailure returns the class and name of err, FailOther for errors that
+// are no Failure.
+func classifyFailure(err error) (string, string) {
+	var f *Failure
+	if errors.As(err, &f) {
+		return f.Class, f.Name
+	}
+	return FailOther, ""
+}
+
+// FailureReport counts the failed tables of the state store by class, and the
+// names of the undefined commands and missing files. Papers rejected as a
+// whole, like those reading files outside of their folder, are counted apart.
+type FailureReport struct {
+	Rendered int
+	Failed   int
+	Classes  map[string]int
+	Names    map[string]map[string]int
+	Papers   map[string]int
+}
+
+// NameCount is a name of a failure class and how often it occurred.
+type NameCount struct {
+	Name  string
+	Count int
+}
+
+func GetFailureReport(store *state.Store) (*FailureReport, error) {
+	report := &FailureReport{Classes: make(map[string]int), Names: make(map[string]map[string]int), Papers: make(map[string]int)}
+	err := store.ForEach(state.KindPaper, func(key string, record state.Record) error {
+		if record.Status == state.StatusFailed {
+			class := record.Class
+			if class == "" {
+				class = FailOther
+			}
+			report.Papers[class]++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = store.ForEach(state.KindTable, func(key string, record state.Record) error {
+		switch record.Status {
+		case state.StatusRendered:
+			report.Rendered++
+		case state.StatusFailed:
+			report.Failed++
+			class := record.Class
+			if class == "" {
+				class = FailOther
+			}
+			report.Classes[class]++
+			if record.Detail != "" {
+				if report.Names[class] == nil {
+					report.Names[class] = make(map[string]int)
+				}
+				report.Names[class][record.Detail]++
+			}
+		}
+		return nil
+	})
+	return report, err
+}
+
+// Top returns the n most frequent names of class, the most frequent first.
+func (r *FailureReport) Top(class string, n int) []NameCount {
+	return topCounts(r.Names[class], n)
+}
+
+// RejectedPapers returns the number of failed papers of every class, the most
+// frequent first.
+func (r *FailureReport) RejectedPapers() []NameCount {
+	return topCounts(r.Papers, len(r.Papers))
+}
+
+// ByClass returns the number of failures of every class, the most frequent
+// first.
+func (r *FailureReport) ByClass() []NameCount {
+	return topCounts(r.Classes, len(r.Classes))
+}
+
+func topCounts(counts map[string]int, n int) []NameCount {
+	result := make([]NameCount, 0, len(counts))
+	for name, count := range counts {
+		result = append(result, NameCount{Name: name, Count: count})
+	}
+	slices.SortFunc(result, func(a, b NameCount) int {
+		if a.Count != b.Count {
+			return cmp.Compare(b.Count, a.Count)
+		}
+		return cmp.Compare(a.Name, b.Name)
+	})
+	return result[:min(max(n, 0), len(result))]
+}
@@ -0,0 +1,142 @@
+package src
+
+import (
+	"slices"
+
+	"latex2image/src/latex"
+)
+
+// commandPackages maps commands used in tables to the packages defining them.
+var commandPackages = map[string][]string{
+	`\toprule`: {"booktabs"}, `\midrule`: {"booktabs"}, `\bottomrule`: {"booktabs"}, `\cmidrule`: {"booktabs"},
+	`\addlinespace`: {"booktabs"}, `\specialrule`: {"booktabs"},
+
+	`\multirow`: {"multirow"},
+
+	`\makecell`: {"makecell"}, `\thead`: {"makecell"}, `\Xhline`: {"makecell"}, `\Xcline`: {"makecell"},
+	`\makegapedcells`: {"makecell"},
+
+	`\cellcolor`: {"xcolor", "colortbl"}, `\rowcolor`: {"xcolor", "colortbl"}, `\columncolor`: {"xcolor", "colortbl"},
+	`\arrayrulecolor`: {"xcolor", "colortbl"}, `\rowcolors`: {"xcolor", "colortbl"},
+	`\textcolor`: {"xcolor"}, `\color`: {"xcolor"}, `\colorbox`: {"xcolor"}, `\fcolorbox`: {"xcolor"},
+	`\definecolor`: {"xcolor"},
+
+	`\checkmark`: {"amssymb"}, `\mathbb`: {"amssymb"}, `\mathfrak`: {"amssymb"}, `\blacksquare`: {"amssymb"},
+	`\square`: {"amssymb"}, `\lesssim`: {"amssymb"}, `\gtrsim`: {"amssymb"}, `\varnothing`: {"amssymb"},
+	`\leqslant`: {"amssymb"}, `\geqslant`: {"amssymb"}, `\triangleq`: {"amssymb"}, `\blacktriangle`: {"amssymb"},
+	`\smallsetminus`: {"amssymb"}, `\nleq`: {"amssymb"}, `\ngeq`: {"amssymb"},
+
+	`\text`: {"amsmath"}, `\dfrac`: {"amsmath"}, `\tfrac`: {"amsmath"}, `\boldsymbol`: {"amsmath"},
+	`\operatorname`: {"amsmath"}, `\binom`: {"amsmath"}, `\overset`: {"amsmath"}, `\underset`: {"amsmath"},
+	`\xrightarrow`: {"amsmath"}, `\xleftarrow`: {"amsmath"}, `\tbinom`: {"amsmath"}, `\DeclareMathOperator`: {"amsmath"},
+	`\coloneqq`: {"mathtools"},
+
+	`\bm`: {"bm"}, `\mathbbm`: {"bbm"}, `\mathds`: {"dsfont"}, `\mathscr`: {"mathrsfs"},
+	`\upmu`: {"upgreek"}, `\upalpha`: {"upgreek"}, `\upbeta`: {"upgreek"},
+
+	`\SI`: {"siunitx"}, `\si`: {"siunitx"}, `\num`: {"siunitx"}, `\qty`: {"siunitx"}, `\unit`: {"siunitx"},
+	`\ang`: {"siunitx"}, `\tablenum`: {"siunitx"}, `\SIrange`: {"siunitx"}, `\numrange`: {"siunitx"},
+
+	`\includegraphics`: {"graphicx"}, `\resizebox`: {"graphicx"}, `\scalebox`: {"graphicx"},
+	`\rotatebox`: {"graphicx"}, `\reflectbox`: {"graphicx"}, `\adjustbox`: {"adjustbox"},
+
+	`\hhline`: {"hhline"}, `\diagbox`: {"diagbox"}, `\hdashline`: {"arydshln"}, `\cdashline`: {"arydshln"},
+	`\bigstrut`: {"bigstrut"}, `\tnote`: {"threeparttable"},
+
+	`\ding`: {"pifont"}, `\textdegree`: {"textcomp"}, `\textmu`: {"textcomp"}, `\degree`: {"gensymb"},
+	`\celsius`: {"gensymb"}, `\hl`: {"soul"}, `\uline`: {"ulem"}, `\sout`: {"ulem"}, `\uwave`: {"ulem"},
+	`\xspace`: {"xspace"},
+}
+
+// columnPackages maps column types to the packages defining them.
+var columnPackages = map[byte]string{
+	'S': "siunitx",
+	'm': "array",
+	'b': "array",
+	'>': "array",
+	'<': "array",
+	'!': "array",
+	'D': "dcolumn",
+	'X': "tabularx",
+}
+
+// envPackages are the packages the compiled document needs for an environment.
+var envPackages = map[string]string{
+	"tabularx":       "tabularx",
+	"tabulary":       "tabulary",
+	"threeparttable": "threeparttable",
+}
+
+// inferPackages returns the packages table needs judging by its environments,
+// column types and commands, in the order they are first used. Tables often
+// tell what they need when the preamble of their paper is unusable.
+func inferPackages(table texTable) []Package {
+	var packages []Package
+	seen := make(map[string]bool)
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			packages = append(packages, Package{Name: name})
+		}
+	}
+
+	for _, env := range []string{table.env, table.float} {
+		if pkg, ok := envPackages[env]; ok {
+			add(pkg)
+		}
+	}
+
+	doc, _ := latex.Parse(table.source)
+	if len(doc.Nodes) > 0 && doc.Nodes[0].Kind == latex.EnvNode {
+		for _, pkg := range specPackages(&doc.Nodes[0]) {
+			add(pkg)
+		}
+	}
+	for _, tok := range doc.Tokens {
+		if tok.Kind != latex.ControlWord {
+			continue
+		}
+		for _, pkg := range commandPackages[tok.Text] {
+			add(pkg)
+		}
+	}
+	return packages
+}
+
+// addPackages appends the packages of extra that packages does not load yet.
+func addPackages(packages, extra []Package) []Package {
+	loaded := make(map[string]bool)
+	for _, pkg := range packages {
+		loaded[pkg.Name] = true
+	}
+	result := slices.Clone(packages)
+	for _, pkg := range extra {
+		if !loaded[pkg.Name] {
+			loaded[pkg.Name] = true
+			result = append(result, pkg)
+		}
+	}
+	return result
+}
+
+// specPackages returns the packages of the column types of a tabular.
+func specPackages(env *latex.Node) []string {
+	spec, ok := tabularSpec(env)
+	if !ok {
+		return nil
+	}
+
+	var names []string
+	for _, n := range spec.Nodes {
+		// the arguments of p{..}, @{..} and >{..} are no column types
+		if n.Kind != latex.TokenNode || n.Token.Kind != latex.Text && n.Token.Kind != latex.Other {
+			continue
+		}
+		for i := 0; i < len(n.Token.Text); i++ {
+			if pkg, ok := columnPackages[n.Token.Text[i]]; ok {
+				names = append(names, pkg)
+			}
+		}
+	}
+	return names
+}
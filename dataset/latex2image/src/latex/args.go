@@ -0,0 +1,125 @@
+package latex
+
+import "strings"
+
+// Arg is an argument of a command.
+type Arg struct {
+	Nodes []Node
+	// source of the argument with its braces or brackets
+	Start int
+	End   int
+	// source of the argument without them
+	BodyStart int
+	BodyEnd   int
+}
+
+// SkipSpace returns the index of the first node at or after i that is not a
+// space or a comment.
+func SkipSpace(nodes []Node, i int) int {
+	for i < len(nodes) && nodes[i].IsSpace() {
+		i++
+	}
+	return i
+}
+
+// NextArg reads the mandatory argument after nodes[i-1]: a group, or else a
+// single token. It returns the index after the argument.
+func NextArg(nodes []Node, i int) (Arg, int, bool) {
+	i = SkipSpace(nodes, i)
+	if i >= len(nodes) {
+		return Arg{}, i, false
+	}
+	n := nodes[i]
+	switch {
+	case n.Kind == GroupNode:
+		return Arg{Nodes: n.Children, Start: n.Start, End: n.End, BodyStart: n.BodyStart, BodyEnd: n.BodyEnd}, i + 1, true
+	case n.Kind == EnvNode, n.Token.Kind == EndGroup, n.Token.Kind == BeginGroup:
+		return Arg{}, i, false
+	}
+	return Arg{Nodes: nodes[i : i+1], Start: n.Start, End: n.End, BodyStart: n.Start, BodyEnd: n.End}, i + 1, true
+}
+
+// NextOptionalArg reads an optional [...] argument after nodes[i-1]. Brackets
+// inside groups do not end it.
+func NextOptionalArg(nodes []Node, i int) (Arg, int, bool) {
+	i = SkipSpace(nodes, i)
+	if i >= len(nodes) || !isOther(&nodes[i], "[") {
+		return Arg{}, i, false
+	}
+	for j := i + 1; j < len(nodes); j++ {
+		if isOther(&nodes[j], "]") {
+			return Arg{Nodes: nodes[i+1 : j], Start: nodes[i].Start, End: nodes[j].End,
+				BodyStart: nodes[i].End, BodyEnd: nodes[j].Start}, j + 1, true
+		}
+	}
+	return Arg{}, i, false
+}
+
+// NextStar reads the * of starred commands like \newcommand*.
+func NextStar(nodes []Node, i int) (int, bool) {
+	j := SkipSpace(nodes, i)
+	if j < len(nodes) && isOther(&nodes[j], "*") {
+		return j + 1, true
+	}
+	return i, false
+}
+
+func isOther(n *Node, text string) bool {
+	return n.Kind == TokenNode && n.Token.Kind == Other && n.Token.Text == text
+}
+
+// Walk calls fn for every node in depth-first order. The children of a node are
+// skipped when fn returns false.
+func Walk(nodes []Node, fn func(n *Node) bool) {
+	for i := range nodes {
+		if fn(&nodes[i]) {
+			Walk(nodes[i].Children, fn)
+		}
+	}
+}
+
+// Uncommented returns the source between start and end without comments.
+func (d *Document) Uncommented(start, end int) string {
+	var b strings.Builder
+	for _, tok := range d.Tokens {
+		if tok.End() <= start || tok.Kind == Comment {
+			continue
+		}
+		if tok.Pos.Offset >= end {
+			break
+		}
+		b.WriteString(d.Source[max(tok.Pos.Offset, start):min(tok.End(), end)])
+	}
+	return b.String()
+}
+
+// ReplaceCommands replaces every control word or symbol named in replacements.
+// Only whole commands match, so replacing \cr leaves \cref alone.
+func ReplaceCommands(src string, replacements map[string]string) string {
+	var b strings.Builder
+	for _, tok := range Tokenize(src) {
+		if replacement, ok := replacements[tok.Text]; ok && (tok.Kind == ControlWord || tok.Kind == ControlSymbol) {
+			b.WriteString(replacement)
+			continue
+		}
+		b.WriteString(tok.Text)
+	}
+	return b.String()
+}
+
+// Join concatenates tokens. A space separates a control word from letters
+// following it, they would be read as part of its name otherwise.
+func Join(tokens []Token) string {
+	var b strings.Builder
+	for i, tok := range tokens {
+		if i > 0 && tokens[i-1].Kind == ControlWord && tok.Kind == Text && isASCIILetter(tok.Text[0]) {
+			b.WriteByte(' ')
+		}
+		b.WriteString(tok.Text)
+	}
+	return b.String()
+}
+
+func isASCIILetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
@@ -0,0 +1,235 @@
+// Package latex splits LaTeX sources into tokens and groups them into a tree of
+// braces and environments. It knows nothing about the meaning of commands, but
+// keeps every byte of the source, so any part of the tree can be cut out of the
+// source again.
+package latex
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+type Kind int
+
+const (
+	// letters, digits and punctuation without a special meaning
+	Text Kind = iota
+	// spaces, tabs and newlines
+	Space
+	// \ followed by letters, e.g. \alpha
+	ControlWord
+	// \ followed by one other character, e.g. \% or \\
+	ControlSymbol
+	BeginGroup
+	EndGroup
+	// $ or $$
+	MathShift
+	// &
+	Alignment
+	// #1 to #9, ## or a lone #
+	Parameter
+	// % up to the end of the line, without the newline
+	Comment
+	// \verb|...| or a whole verbatim environment
+	Verbatim
+	// one of [ ] * = ~ ^ _
+	Other
+)
+
+var kindNames = [...]string{"text", "space", "control word", "control symbol", "begin group", "end group",
+	"math shift", "alignment", "parameter", "comment", "verbatim", "other"}
+
+func (k Kind) String() string {
+	if int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return fmt.Sprintf("kind(%d)", int(k))
+}
+
+// Pos is a position in the source. Line and Col start at 1, Col counts runes.
+type Pos struct {
+	Offset int
+	Line   int
+	Col    int
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Col)
+}
+
+type Token struct {
+	Kind Kind
+	// the source of the token
+	Text string
+	Pos  Pos
+}
+
+// End returns the offset after the token.
+func (t Token) End() int {
+	return t.Pos.Offset + len(t.Text)
+}
+
+// Name returns the name of a control word or symbol without the backslash.
+func (t Token) Name() string {
+	return strings.TrimPrefix(t.Text, "\\")
+}
+
+// verbatimEnvs are environments whose body is not tokenized.
+var verbatimEnvs = regexp.MustCompile(`^\\begin\s*\{(verbatim\*?|Verbatim\*?|BVerbatim|lstlisting|minted|comment)\}`)
+
+// Tokenize splits src into tokens. Concatenating the text of the tokens gives
+// src again. \makeatletter and \makeatother switch whether @ is a letter.
+func Tokenize(src string) []Token {
+	return newLexer(src, false).run()
+}
+
+// TokenizePackage tokenizes a .sty or .cls file, in which @ is a letter.
+func TokenizePackage(src string) []Token {
+	return newLexer(src, true).run()
+}
+
+type lexer struct {
+	src      string
+	offset   int
+	line     int
+	col      int
+	atLetter bool
+	tokens   []Token
+}
+
+func newLexer(src string, atLetter bool) *lexer {
+	return &lexer{src: src, line: 1, col: 1, atLetter: atLetter}
+}
+
+func (l *lexer) run() []Token {
+	for l.offset < len(l.src) {
+		kind, size := l.next()
+		l.emit(kind, size)
+	}
+	return l.tokens
+}
+
+// emit adds the next size bytes as a token and moves the position past them.
+func (l *lexer) emit(kind Kind, size int) {
+	text := l.src[l.offset : l.offset+size]
+	l.tokens = append(l.tokens, Token{Kind: kind, Text: text, Pos: Pos{Offset: l.offset, Line: l.line, Col: l.col}})
+	l.offset += size
+	if newlines := strings.Count(text, "\n"); newlines > 0 {
+		l.line += newlines
+		l.col = utf8.RuneCountInString(text[strings.LastIndexByte(text, '\n')+1:]) + 1
+	} else {
+		l.col += utf8.RuneCountInString(text)
+	}
+}
+
+// next returns the kind and length of the token at the current offset.
+func (l *lexer) next() (Kind, int) {
+	rest := l.src[l.offset:]
+	switch c := rest[0]; c {
+	case '\\':
+		return l.control(rest)
+	case '{':
+		return BeginGroup, 1
+	case '}':
+		return EndGroup, 1
+	case '$':
+		if strings.HasPrefix(rest, "$$") {
+			return MathShift, 2
+		}
+		return MathShift, 1
+	case '&':
+		return Alignment, 1
+	case '#':
+		if len(rest) > 1 && (rest[1] >= '1' && rest[1] <= '9' || rest[1] == '#') {
+			return Parameter, 2
+		}
+		return Parameter, 1
+	case '%':
+		if end := strings.IndexByte(rest, '\n'); end >= 0 {
+			return Comment, end
+		}
+		return Comment, len(rest)
+	case '[', ']', '*', '=', '~', '^', '_':
+		return Other, 1
+	}
+
+	if isSpace(rest[0]) {
+		size := 1
+		for size < len(rest) && isSpace(rest[size]) {
+			size++
+		}
+		return Space, size
+	}
+	size := 0
+	for size < len(rest) && !isSpecial(rest[size]) && !isSpace(rest[size]) {
+		size++
+	}
+	return Text, size
+}
+
+func (l *lexer) control(rest string) (Kind, int) {
+	if len(rest) == 1 {
+		return ControlSymbol, 1
+	}
+	size := 1
+	for size < len(rest) && l.isLetter(rest[size]) {
+		size++
+	}
+	if size == 1 {
+		_, runeSize := utf8.DecodeRuneInString(rest[1:])
+		return ControlSymbol, 1 + runeSize
+	}
+
+	switch rest[:size] {
+	case "\\makeatletter":
+		l.atLetter = true
+	case "\\makeatother":
+		l.atLetter = false
+	case "\\verb":
+		return verb(rest, size)
+	case "\\begin":
+		if match := verbatimEnvs.FindStringSubmatch(rest); match != nil {
+			end := "\\end{" + match[1] + "}"
+			if index := strings.Index(rest[len(match[0]):], end); index >= 0 {
+				return Verbatim, len(match[0]) + index + len(end)
+			}
+			return Verbatim, len(rest)
+		}
+	}
+	return ControlWord, size
+}
+
+// verb measures \verb|...| and \verb*|...|, which end at the next delimiter or
+// at the end of the line.
+func verb(rest string, size int) (Kind, int) {
+	if size < len(rest) && rest[size] == '*' {
+		size++
+	}
+	if size == len(rest) || isSpace(rest[size]) {
+		return ControlWord, len("\\verb")
+	}
+	delim := rest[size]
+	for end := size + 1; end < len(rest); end++ {
+		switch rest[end] {
+		case delim:
+			return Verbatim, end + 1
+		case '\n':
+			return Verbatim, end
+		}
+	}
+	return Verbatim, len(rest)
+}
+
+func (l *lexer) isLetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '@' && l.atLetter
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isSpecial(c byte) bool {
+	return strings.IndexByte("\\{}$&#%[]*=~^_", c) >= 0
+}
@@ -0,0 +1,84 @@
+package latex
+
+import (
+	"strings"
+	"testing"
+)
+
+// describe lists the kinds and texts of tokens, like "control word \a|text b".
+func describe(tokens []Token) string {
+	parts := make([]string, len(tokens))
+	for i, tok := range tokens {
+		parts[i] = tok.Kind.String() + " " + tok.Text
+	}
+	return strings.Join(parts, "|")
+}
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`\alpha2`, `control word \alpha|text 2`},
+		{`\\ \% \é`, `control symbol \\|space  |control symbol \%|space  |control symbol \é`},
+		{"a  b\n\nc", "text a|space   |text b|space \n\n|text c"},
+		{`{$x$}&#1##`, `begin group {|math shift $|text x|math shift $|end group }|alignment &|parameter #1|parameter ##`},
+		{`$$x$$`, `math shift $$|text x|math shift $$`},
+		{"a% comment\nb", "text a|comment % comment|space \n|text b"},
+		{`x[1]*=~^_`, `text x|other [|text 1|other ]|other *|other =|other ~|other ^|other _`},
+		// @ is a letter only between \makeatletter and \makeatother
+		{`\a@b\makeatletter\a@b\makeatother\a@b`, `control word \a|text @b|control word \makeatletter|control word \a@b|control word \makeatother|control word \a|text @b`},
+		{`\verb|\x{|y`, `verbatim \verb|\x{||text y`},
+		{`\verb*+a b+`, `verbatim \verb*+a b+`},
+		{"\\verb|open\nx", "verbatim \\verb|open|space \n|text x"},
+		{`\begin{verbatim}\x{\end{verbatim}y`, `verbatim \begin{verbatim}\x{\end{verbatim}|text y`},
+		{`\begin{comment}}\end{comment}`, `verbatim \begin{comment}}\end{comment}`},
+		{`\`, `control symbol \`},
+	}
+	for _, tt := range tests {
+		tokens := Tokenize(tt.src)
+		if got := describe(tokens); got != tt.want {
+			t.Errorf("Tokenize(%q) = %s, want %s", tt.src, got, tt.want)
+		}
+		var b strings.Builder
+		for _, tok := range tokens {
+			b.WriteString(tok.Text)
+		}
+		if b.String() != tt.src {
+			t.Errorf("Tokenize(%q) lost bytes: %q", tt.src, b.String())
+		}
+	}
+}
+
+func TestTokenizePackage(t *testing.T) {
+	if got, want := describe(TokenizePackage(`\a@b\makeatother\a@b`)), `control word \a@b|control word \makeatother|control word \a|text @b`; got != want {
+		t.Errorf("TokenizePackage = %s, want %s", got, want)
+	}
+}
+
+func TestTokenPositions(t *testing.T) {
+	tokens := Tokenize("ä\n  \\x{y}")
+	want := []Pos{{0, 1, 1}, {2, 1, 2}, {5, 2, 3}, {7, 2, 5}, {8, 2, 6}, {9, 2, 7}}
+	if len(tokens) != len(want) {
+		t.Fatalf("got %d tokens, want %d", len(tokens), len(want))
+	}
+	for i, tok := range tokens {
+		if tok.Pos != want[i] {
+			t.Errorf("position of %q = %+v, want %+v", tok.Text, tok.Pos, want[i])
+		}
+	}
+}
+
+func TestJoin(t *testing.T) {
+	tokens := []Token{{Kind: ControlWord, Text: `\bf`}, {Kind: Text, Text: "bold"}, {Kind: ControlWord, Text: `\x`}, {Kind: Text, Text: "2"}}
+	if got, want := Join(tokens), `\bf bold\x2`; got != want {
+		t.Errorf("Join = %q, want %q", got, want)
+	}
+}
+
+func TestReplaceCommands(t *testing.T) {
+	got := ReplaceCommands(`\cr\cref{a}\\`, map[string]string{`\cr`: `\\`, `\\`: "X"})
+	if want := `\\\cref{a}X`; got != want {
+		t.Errorf("ReplaceCommands = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,278 @@
+package latex
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type NodeKind int
+
+const (
+	// a single token
+	TokenNode NodeKind = iota
+	// {...}
+	GroupNode
+	// \begin{name}...\end{name}
+	EnvNode
+)
+
+// Node is a token, a brace group or an environment. Offsets are byte offsets in
+// the source, End is exclusive.
+type Node struct {
+	Kind NodeKind
+	// the token of a TokenNode, the { or \begin of a group or environment
+	Token Token
+	// environment name
+	Name string
+	// content of a group or environment
+	Children []Node
+	Start    int
+	End      int
+	// content without the braces of a group, or between \begin{name} and
+	// \end{name} of an environment
+	BodyStart int
+	BodyEnd   int
+}
+
+func (n *Node) Pos() Pos {
+	return n.Token.Pos
+}
+
+// IsCommand reports whether n is the control word or symbol name, given with
+// its backslash.
+func (n *Node) IsCommand(name string) bool {
+	return n.Kind == TokenNode && (n.Token.Kind == ControlWord || n.Token.Kind == ControlSymbol) && n.Token.Text == name
+}
+
+func (n *Node) IsEnv(name string) bool {
+	return n.Kind == EnvNode && n.Name == name
+}
+
+// IsSpace reports whether n is skipped between a command and its arguments.
+func (n *Node) IsSpace() bool {
+	return n.Kind == TokenNode && (n.Token.Kind == Space || n.Token.Kind == Comment)
+}
+
+// Error is a problem in the source, like an unclosed group.
+type Error struct {
+	Pos Pos
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%v: %s", e.Pos, e.Msg)
+}
+
+type Document struct {
+	Source string
+	Tokens []Token
+	Nodes  []Node
+}
+
+// Text returns the source of n.
+func (d *Document) Text(n *Node) string {
+	return d.Source[n.Start:n.End]
+}
+
+// Body returns the content of a group or environment.
+func (d *Document) Body(n *Node) string {
+	return d.Source[n.BodyStart:n.BodyEnd]
+}
+
+// Parse tokenizes src and builds its tree. Unbalanced braces and environments
+// are reported in the error; their tokens stay in the tree as plain tokens, so
+// the document is usable anyway.
+func Parse(src string) (*Document, error) {
+	return parseTokens(src, Tokenize(src))
+}
+
+// ParsePackage parses a .sty or .cls file, in which @ is a letter.
+func ParsePackage(src string) (*Document, error) {
+	return parseTokens(src, TokenizePackage(src))
+}
+
+func parseTokens(src string, tokens []Token) (*Document, error) {
+	p := &parser{tokens: tokens}
+	nodes, _ := p.parseSeq()
+	return &Document{Source: src, Tokens: tokens, Nodes: nodes}, errors.Join(p.errs...)
+}
+
+// how a sequence of nodes ended
+type seqEnd int
+
+const (
+	endEOF seqEnd = iota
+	// the } of the innermost group was consumed
+	endGroup
+	// the \end{name} of the innermost environment was consumed
+	endEnv
+	// a } or \end closes an outer group or environment, it was not consumed
+	endUnwind
+)
+
+type frame struct {
+	env  bool
+	name string
+}
+
+type parser struct {
+	tokens []Token
+	i      int
+	frames []frame
+	// offset of the \end that closed the last environment
+	endStart int
+	errs     []error
+}
+
+func (p *parser) errorf(pos Pos, format string, args ...any) {
+	p.errs = append(p.errs, &Error{Pos: pos, Msg: fmt.Sprintf(format, args...)})
+}
+
+func (p *parser) leaf(tok Token) Node {
+	return Node{Kind: TokenNode, Token: tok, Start: tok.Pos.Offset, End: tok.End()}
+}
+
+func (p *parser) top() (frame, bool) {
+	if len(p.frames) == 0 {
+		return frame{}, false
+	}
+	return p.frames[len(p.frames)-1], true
+}
+
+func (p *parser) open(env bool, name string) bool {
+	for _, f := range p.frames {
+		if f.env == env && (!env || f.name == name) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSeq parses nodes until the end of the innermost group or environment.
+func (p *parser) parseSeq() ([]Node, seqEnd) {
+	var nodes []Node
+	for p.i < len(p.tokens) {
+		tok := p.tokens[p.i]
+		switch {
+		case tok.Kind == BeginGroup:
+			p.i++
+			p.frames = append(p.frames, frame{})
+			children, end := p.parseSeq()
+			p.frames = p.frames[:len(p.frames)-1]
+			if end == endGroup {
+				closing := p.tokens[p.i-1]
+				nodes = append(nodes, Node{Kind: GroupNode, Token: tok, Children: children,
+					Start: tok.Pos.Offset, End: closing.End(), BodyStart: tok.End(), BodyEnd: closing.Pos.Offset})
+				continue
+			}
+			p.errorf(tok.Pos, "{ is not closed")
+			nodes = append(nodes, p.leaf(tok))
+			nodes = append(nodes, children...)
+			if end == endEOF {
+				return nodes, endEOF
+			}
+
+		case tok.Kind == EndGroup:
+			if f, ok := p.top(); ok && !f.env {
+				p.i++
+				return nodes, endGroup
+			}
+			if p.open(false, "") {
+				return nodes, endUnwind
+			}
+			p.errorf(tok.Pos, "unexpected }")
+			nodes = append(nodes, p.leaf(tok))
+			p.i++
+
+		case tok.Kind == ControlWord && tok.Text == "\\begin":
+			name, next, ok := p.envName(p.i + 1)
+			if !ok {
+				nodes = append(nodes, p.leaf(tok))
+				p.i++
+				continue
+			}
+			bodyStart := p.tokens[next-1].End()
+			p.i = next
+			p.frames = append(p.frames, frame{env: true, name: name})
+			children, end := p.parseSeq()
+			p.frames = p.frames[:len(p.frames)-1]
+			if end == endEnv {
+				closing := p.tokens[p.i-1]
+				nodes = append(nodes, Node{Kind: EnvNode, Token: tok, Name: name, Children: children,
+					Start: tok.Pos.Offset, End: closing.End(), BodyStart: bodyStart, BodyEnd: p.endStart})
+				continue
+			}
+			p.errorf(tok.Pos, "\\begin{%s} is not closed", name)
+			for j := p.indexOf(tok); j < p.indexAt(bodyStart); j++ {
+				nodes = append(nodes, p.leaf(p.tokens[j]))
+			}
+			nodes = append(nodes, children...)
+			if end == endEOF {
+				return nodes, endEOF
+			}
+
+		case tok.Kind == ControlWord && tok.Text == "\\end":
+			name, next, ok := p.envName(p.i + 1)
+			if ok {
+				if f, isTop := p.top(); isTop && f.env && f.name == name {
+					p.endStart = tok.Pos.Offset
+					p.i = next
+					return nodes, endEnv
+				}
+				if p.open(true, name) {
+					return nodes, endUnwind
+				}
+				p.errorf(tok.Pos, "\\end{%s} without \\begin", name)
+			}
+			nodes = append(nodes, p.leaf(tok))
+			p.i++
+
+		default:
+			nodes = append(nodes, p.leaf(tok))
+			p.i++
+		}
+	}
+	return nodes, endEOF
+}
+
+// envName reads {name} after \begin or \end, it returns the index after the }.
+func (p *parser) envName(i int) (string, int, bool) {
+	for i < len(p.tokens) && p.tokens[i].Kind == Space {
+		i++
+	}
+	if i >= len(p.tokens) || p.tokens[i].Kind != BeginGroup {
+		return "", 0, false
+	}
+	var name strings.Builder
+	for i++; i < len(p.tokens); i++ {
+		switch p.tokens[i].Kind {
+		case EndGroup:
+			trimmed := strings.TrimSpace(name.String())
+			return trimmed, i + 1, trimmed != ""
+		case Text, Other, Space:
+			name.WriteString(p.tokens[i].Text)
+		default:
+			return "", 0, false
+		}
+	}
+	return "", 0, false
+}
+
+func (p *parser) indexOf(tok Token) int {
+	return p.indexAt(tok.Pos.Offset)
+}
+
+// indexAt returns the index of the token starting at offset.
+func (p *parser) indexAt(offset int) int {
+	lo, hi := 0, len(p.tokens)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if p.tokens[mid].Pos.Offset < offset {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo
+}
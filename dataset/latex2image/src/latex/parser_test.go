@@ -0,0 +1,118 @@
+package latex
+
+import (
+	"strings"
+	"testing"
+)
+
+// tree prints nodes as groups {...}, environments <name ...> and the text of
+// tokens.
+func tree(nodes []Node) string {
+	var b strings.Builder
+	for _, n := range nodes {
+		switch n.Kind {
+		case GroupNode:
+			b.WriteString("{" + tree(n.Children) + "}")
+		case EnvNode:
+			b.WriteString("<" + n.Name + " " + tree(n.Children) + ">")
+		default:
+			b.WriteString(n.Token.Text)
+		}
+	}
+	return b.String()
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+		err  string
+	}{
+		{`a{b{c}}d`, `a{b{c}}d`, ""},
+		{`\begin{table}x\begin{tabular}{ll}a&b\end{tabular}\end{table}`, `<table x<tabular {ll}a&b>>`, ""},
+		{`\begin {tabular*} {x}\end{tabular*}`, `<tabular*  {x}>`, ""},
+		{`\begin{a}\begin{a}x\end{a}\end{a}`, `<a <a x>>`, ""},
+		{`\verb|}|{x}`, `\verb|}|{x}`, ""},
+		{`\begin{verbatim}\end{table}\end{verbatim}`, `\begin{verbatim}\end{table}\end{verbatim}`, ""},
+		// unbalanced sources keep their tokens
+		{`a{b`, `a{b`, "1:2: { is not closed"},
+		{`a}b`, `a}b`, "1:2: unexpected }"},
+		{`\begin{table}{x\end{table}`, `<table {x>`, "1:14: { is not closed"},
+		{`\begin{table}x`, `\begin{table}x`, `1:1: \begin{table} is not closed`},
+		{`x\end{table}`, `x\end{table}`, `1:2: \end{table} without \begin`},
+		{`{\begin{a}}`, `{\begin{a}}`, `1:2: \begin{a} is not closed`},
+	}
+	for _, tt := range tests {
+		doc, err := Parse(tt.src)
+		if got := tree(doc.Nodes); got != tt.want {
+			t.Errorf("Parse(%q) = %s, want %s", tt.src, got, tt.want)
+		}
+		gotErr := ""
+		if err != nil {
+			gotErr = err.Error()
+		}
+		if gotErr != tt.err {
+			t.Errorf("Parse(%q) error = %q, want %q", tt.src, gotErr, tt.err)
+		}
+	}
+}
+
+func TestParseOffsets(t *testing.T) {
+	src := `x\begin{tabular}{l}a\end{tabular}`
+	doc, err := Parse(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := &doc.Nodes[1]
+	if !env.IsEnv("tabular") {
+		t.Fatalf("node 1 is %s", tree(doc.Nodes[1:2]))
+	}
+	if got := doc.Text(env); got != src[1:] {
+		t.Errorf("Text = %q", got)
+	}
+	if got := doc.Body(env); got != "{l}a" {
+		t.Errorf("Body = %q", got)
+	}
+	if got := doc.Body(&env.Children[0]); got != "l" {
+		t.Errorf("Body of the group = %q", got)
+	}
+}
+
+func TestArgs(t *testing.T) {
+	src := `\newcommand* {\a}[2][x{]}]{body} % c` + "\n" + `\b`
+	doc, _ := Parse(src)
+	nodes := doc.Nodes
+	i, ok := NextStar(nodes, 1)
+	if !ok {
+		t.Fatal("no star")
+	}
+	body := func(arg Arg) string { return src[arg.BodyStart:arg.BodyEnd] }
+
+	name, i, ok := NextArg(nodes, i)
+	if !ok || body(name) != `\a` || src[name.Start:name.End] != `{\a}` {
+		t.Fatalf("name = %q, %v", body(name), ok)
+	}
+	nargs, i, ok := NextOptionalArg(nodes, i)
+	if !ok || body(nargs) != "2" {
+		t.Fatalf("number of arguments = %q, %v", body(nargs), ok)
+	}
+	def, i, ok := NextOptionalArg(nodes, i)
+	if !ok || body(def) != "x{]}" {
+		t.Fatalf("default = %q, %v", body(def), ok)
+	}
+	if _, _, ok := NextOptionalArg(nodes, i); ok {
+		t.Fatal("found a second default")
+	}
+	arg, i, ok := NextArg(nodes, i)
+	if !ok || body(arg) != "body" {
+		t.Fatalf("body = %q, %v", body(arg), ok)
+	}
+	// comments and spaces are skipped, a single token is an argument
+	next, _, ok := NextArg(nodes, i)
+	if !ok || body(next) != `\b` {
+		t.Fatalf("next = %q, %v", body(next), ok)
+	}
+	if got := doc.Uncommented(arg.End, len(src)); got != " \n\\b" {
+		t.Errorf("Uncommented = %q", got)
+	}
+}
@@ -4,28 +4,36 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"image/png"
+	"io/fs"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
-	"regexp"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 	"unicode"
 
+	"latex2image/src/assemble"
+	"latex2image/src/latex"
+	"latex2image/src/macro"
+
 	"github.com/gen2brain/go-fitz"
 )
 
-var MAP_DATASET_COUNT = make(map[string]int)
+var metaInfoMu sync.Mutex
 
-func FindTexFiles(root string) ([]string, error) {
+func FindTexFiles(fsys fs.FS) ([]string, error) {
 	var files []string
-	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+	err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
-		if !info.IsDir() && strings.HasSuffix(info.Name(), ".tex") {
+		if !d.IsDir() && strings.HasSuffix(d.Name(), ".tex") {
 			files = append(files, path)
 		}
 		return nil
@@ -33,7 +41,56 @@ func FindTexFiles(root string) ([]string, error) {
 	return files, err
 }
 
-func ExtractPreamble(content string, basePath string) (result string, err error) {
+// AssembleDocuments returns the document of the root of a paper, so stray
+// templates and drafts are left out. Without a root it inlines the files loaded
+// by every .tex file: the documents with a \begin{document} come first, then
+// every file none of them loads on its own, so each file ends up in exactly one
+// document. The problems of assembling them, like missing files, are returned
+// in the error.
+func AssembleDocuments(paper Paper, files []string, opts assemble.Options) ([]*assemble.Document, error) {
+	if doc, err := FindRoot(paper, files, opts); doc != nil {
+		return []*assemble.Document{doc}, err
+	}
+
+	var roots, others []string
+	for _, file := range files {
+		content, err := fs.ReadFile(paper.FS, file)
+		if err != nil {
+			continue
+		}
+		if beginDocument(latex.Tokenize(string(content))) >= 0 {
+			roots = append(roots, file)
+		} else {
+			others = append(others, file)
+		}
+	}
+
+	var docs []*assemble.Document
+	var errs []error
+	assembled := make(map[string]bool)
+	for _, file := range append(roots, others...) {
+		if assembled[file] {
+			continue
+		}
+		doc, err := assemble.Assemble(paper.FS, file, opts)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if doc == nil {
+			continue
+		}
+		for _, f := range doc.Files {
+			assembled[f] = true
+		}
+		docs = append(docs, doc)
+	}
+	return docs, errors.Join(errs...)
+}
+
+// ExtractPreamble returns the \usepackage lines and macro definitions of the
+// preamble of an assembled document, leaving out those that are commented out
+// or in dead code.
+func ExtractPreamble(content string) (result string, err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			err = fmt.Errorf("occur panic: %v", r)
@@ -41,222 +98,211 @@ func ExtractPreamble(content string, basePath string) (result string, err error)
 		}
 	}()
 
-	// reStart := regexp.MustCompile(`(?m)^\s*\\documentclass.*$`)
-	// startIndex := reStart.FindStringIndex(content)
-	// if startIndex == nil {
-	// 	return "", fmt.Errorf("未找到 \\documentclass")
-	// }
-
-	reEnd := regexp.MustCompile(`(?m)^\s*\\begin\{document\}.*$`)
-	endIndex := reEnd.FindStringIndex(content)
-	if endIndex == nil {
+	content = preprocess(content).text
+	doc, _ := latex.Parse(content)
+	endIndex := beginDocument(doc.Tokens)
+	if endIndex < 0 {
 		return "", fmt.Errorf("not found \\begin{document}")
 	}
 
-	fullPreamble := content[:endIndex[0]]
-
-	// only keep \input \newcommand \def
-	reKeep := regexp.MustCompile(`(?m)^\s*(\\input|\\newcommand|\\def).*$`)
-	matches := reKeep.FindAllString(fullPreamble, -1)
-
-	preamble := strings.Join(matches, "\n")
-
-	// process \input
-	processedPreamble, err := processInput(preamble, basePath, 0)
-	if err != nil {
-		return "", err
-	}
-
-	reFinalKeep := regexp.MustCompile(`(?m)^\s*(\\newcommand|\\def).*$`)
-	finalMatches := reFinalKeep.FindAllString(processedPreamble, -1)
-
-	finalPreamble := strings.Join(finalMatches, "\n")
-
-	return finalPreamble, nil
+	// only keep \usepackage and the definitions
+	return strings.Join(findStatements(content[:endIndex], append([]string{`\usepackage`}, definitionCommands...)...), "\n"), nil
 }
 
-func removeAfterPercent(s string) string {
-	index := strings.Index(s, "%")
-	if index != -1 {
-		return s[:index]
-	}
-	return s
-}
-
-func containCommand(text string, commandMap map[string]string) bool {
-	for name := range commandMap {
-		contain := strings.Contains(text, name)
-		if contain {
-			return true
+// definitionCommands are the commands that define macros and column types.
+// \makeatletter and \makeatother are kept with them, they decide how names with
+// @ are read.
+var definitionCommands = []string{`\newcommand`, `\renewcommand`, `\providecommand`,
+	`\def`, `\gdef`, `\edef`, `\xdef`, `\let`, `\DeclareMathOperator`, `\newcolumntype`,
+	`\makeatletter`, `\makeatother`}
+
+// beginDocument returns the offset of \begin{document}, or -1.
+func beginDocument(tokens []latex.Token) int {
+	for i, tok := range tokens {
+		if tok.Kind == latex.ControlWord && tok.Text == `\begin` && i+3 < len(tokens) &&
+			tokens[i+1].Kind == latex.BeginGroup && tokens[i+2].Text == "document" && tokens[i+3].Kind == latex.EndGroup {
+			return tok.Pos.Offset
 		}
 	}
-	return false
+	return -1
 }
 
-func replaceMacro(text string, macroMap map[string]string) string {
-	for macroName, macroDef := range macroMap {
-		text = strings.ReplaceAll(text, macroName, macroDef)
+// visitCommands calls fn for every control word in names, also inside groups and
+// environments. fn returns the index of the node after the command and its
+// arguments, or i to go on right after the command.
+func visitCommands(nodes []latex.Node, names []string, fn func(nodes []latex.Node, i int) int) {
+	for i := 0; i < len(nodes); i++ {
+		n := &nodes[i]
+		if n.Kind != latex.TokenNode {
+			visitCommands(n.Children, names, fn)
+			continue
+		}
+		if n.Token.Kind == latex.ControlWord && slices.Contains(names, n.Token.Text) {
+			if next := fn(nodes, i); next > i {
+				i = next - 1
+			}
+		}
 	}
-	return text
 }
 
-func parseLaTeXMacro(def string) (string, string, string, string) {
-	def = strings.TrimSpace(def)
-
-	var macroName, macroDef string
-	var commandName, commandDef string
-
-	if strings.HasPrefix(def, "\\def") {
-		def = strings.TrimPrefix(def, "\\def")
-		def = strings.TrimLeftFunc(def, unicode.IsSpace) // remove \def space
-
-		// get def name
-		endOfMacroName := strings.IndexFunc(def, func(r rune) bool {
-			return unicode.IsSpace(r) || r == '{'
-		})
-		if endOfMacroName == -1 {
-			return "", "", "", ""
+// findStatements returns the source of every command in names together with its
+// arguments, e.g. a whole \newcommand{\name}[1]{body} spanning several lines.
+func findStatements(content string, names ...string) []string {
+	doc, _ := latex.Parse(content)
+	var statements []string
+	visitCommands(doc.Nodes, names, func(nodes []latex.Node, i int) int {
+		end, ok := statementEnd(nodes, i)
+		if !ok {
+			return i
 		}
-		macroName = def[:endOfMacroName]
-		def = def[endOfMacroName:]
+		statements = append(statements, content[nodes[i].Start:nodes[end-1].End])
+		return end
+	})
+	return statements
+}
 
-		// get def value
-		def = strings.TrimLeftFunc(def, unicode.IsSpace) // remove def space
-		if !strings.HasPrefix(def, "{") {
-			return "", "", "", ""
-		}
-		macroDef = strings.TrimPrefix(def, "{")
-	} else if strings.HasPrefix(def, "\\newcommand") {
-		// process \newcommand
-		def = strings.TrimPrefix(def, "\\newcommand")
-		def = strings.TrimLeftFunc(def, unicode.IsSpace) // remove \newcommand space
-
-		// get name
-		if !strings.HasPrefix(def, "{") {
-			return "", "", "", ""
+// statementEnd returns the index of the node after the arguments of the
+// \usepackage or definition at nodes[i].
+func statementEnd(nodes []latex.Node, i int) (int, bool) {
+	switch nodes[i].Token.Text {
+	case `\usepackage`, `\RequirePackage`:
+		_, next, _ := latex.NextOptionalArg(nodes, i+1)
+		_, next, ok := latex.NextArg(nodes, next)
+		// release date the package must have
+		if _, after, ok := latex.NextOptionalArg(nodes, next); ok {
+			next = after
 		}
-		closeBrace := strings.Index(def, "}")
-		if closeBrace == -1 {
-			return "", "", "", ""
+		return next, ok
+	case `\newcommand`, `\renewcommand`, `\providecommand`, `\newcolumntype`:
+		next, _ := latex.NextStar(nodes, i+1)
+		_, next, ok := latex.NextArg(nodes, next)
+		if !ok {
+			return 0, false
 		}
-		commandName = strings.Trim(def[:closeBrace+1], "{}")
-		def = def[closeBrace+1:]
-
-		// process param
-		if strings.HasPrefix(def, "[") {
-			closeBracket := strings.Index(def, "]")
-			if closeBracket != -1 {
-				def = def[closeBracket+1:]
-			}
+		// number of arguments and default of the first one
+		_, next, _ = latex.NextOptionalArg(nodes, next)
+		_, next, _ = latex.NextOptionalArg(nodes, next)
+		body, next, ok := latex.NextArg(nodes, next)
+		return next, ok && len(body.Nodes) > 0 && body.Start != body.BodyStart
+	case `\DeclareMathOperator`:
+		next, _ := latex.NextStar(nodes, i+1)
+		_, next, ok := latex.NextArg(nodes, next)
+		if !ok {
+			return 0, false
 		}
-
-		// process '{'
-		if !strings.HasPrefix(def, "{") {
-			return "", "", "", ""
-		}
-
-		commandDef = strings.TrimPrefix(def, "{")
-	} else {
-		return "", "", "", ""
+		_, next, ok = latex.NextArg(nodes, next)
+		return next, ok
+	case `\def`, `\gdef`, `\edef`, `\xdef`:
+		_, body, ok := macro.DefParts(nodes, i)
+		return body + 1, ok
+	case `\let`:
+		_, target, ok := macro.LetEnd(nodes, i)
+		return target + 1, ok
+	case `\makeatletter`, `\makeatother`:
+		return i + 1, true
 	}
-
-	macroDef = strings.TrimSuffix(macroDef, "}")
-	commandDef = strings.TrimSuffix(commandDef, "}")
-
-	return macroName, macroDef, commandName, commandDef
+	return 0, false
 }
 
-func ProcessTexFile(DOC_HEAD string, filePath string, bashPath string, trainDataset string, totalDatasetCount int, isDebug bool) bool {
-	if _, exists := MAP_DATASET_COUNT[trainDataset]; !exists {
-		MAP_DATASET_COUNT[trainDataset] = 0
-	}
-
-	if _, err := os.Stat(trainDataset); os.IsNotExist(err) {
-		err := os.MkdirAll(trainDataset, 0755)
-		if err != nil {
-			fmt.Errorf("failed to create directory: %v", err)
-			return false
-		}
+// ProcessTexFile extracts the tables of an assembled document as standalone
+// documents. Every table is named after the file it was written in and
+// numbered within that file.
+// Only the packages of the paper that filter allows are loaded, along with the
+// allowed packages the commands and columns of a table need. Tables whose
+// macros or column types cannot be expanded are returned with their error.
+func ProcessTexFile(DOC_HEAD string, paper Paper, doc *assemble.Document, filter *PackageFilter) []tableJob {
+	fmt.Printf("Processing file: %s/%s\n", paper.ID, doc.Root)
+
+	// tables in comments, comment environments and \iffalse are not harvested
+	source := preprocess(doc.Source)
+	tables, err := extractTables(source.text)
+	if err != nil {
+		fmt.Printf("Warning parsing %s/%s:\n%v\n", paper.ID, doc.Root, err)
 	}
 
-	fmt.Printf("Processing file: %s\n", filePath)
-
-	latexContent, err := os.ReadFile(filePath)
-	if err != nil {
-		fmt.Printf("Error reading file %s: %v\n", filePath, err)
-		return true
+	// the tables are stored with the macros of the paper expanded, so they do
+	// not depend on the preamble
+	macros := macro.NewSet()
+	if err := macros.Collect(DOC_HEAD); err != nil {
+		fmt.Printf("Warning reading macros of %s/%s:\n%v\n", paper.ID, doc.Root, err)
 	}
-	tables := extractTables(string(latexContent))
+	columnTypes := collectColumnTypes(DOC_HEAD)
 
-	filename := strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath))
-	parentDir := filepath.Base(filepath.Dir(filePath))
-	baseName := parentDir + "_" + filename
+	packages := filter.Filter(collectPackages(DOC_HEAD))
 
-	for i, table := range tables {
-		if MAP_DATASET_COUNT[trainDataset] >= totalDatasetCount {
-			return false
+	var jobs []tableJob
+	indexes := make(map[string]int)
+	for _, table := range tables {
+		filePath := doc.Root
+		if loc, ok := doc.Locate(source.origin(table.start)); ok {
+			filePath = loc.File
 		}
+		i := indexes[filePath]
+		indexes[filePath]++
 
-		// remove tabs and spaces
-		table = strings.ReplaceAll(table, "\t", "")
-		// table = strings.ReplaceAll(table, " ", "")
-		fullLatex := createFullLatexDocument(DOC_HEAD, table)
-		if fullLatex == "" {
-			continue
-		}
-		tmpName := fmt.Sprintf("%s_table_%d.", baseName, i)
-		// tableTexFile := filepath.Join(filepath.Dir(filePath), fmt.Sprintf("%stex", tmpName))
-		// tablePdfFile := filepath.Join(filepath.Dir(filePath), fmt.Sprintf("%spdf", tmpName))
-		tableTexFile := filepath.Join(trainDataset, fmt.Sprintf("%stex", tmpName))
-		tablePdfFile := filepath.Join(trainDataset, fmt.Sprintf("%spdf", tmpName))
-		tmpLog := filepath.Join(trainDataset, fmt.Sprintf("%slog", tmpName))
-		tmpAux := filepath.Join(trainDataset, fmt.Sprintf("%saux", tmpName))
-
-		err = os.WriteFile(tableTexFile, []byte(fullLatex), 0644)
-		if err != nil {
-			fmt.Printf("Error writing temp file for %s: %v\n", filePath, err)
-			continue
+		table.source, err = macros.Expand(table.source)
+		if err == nil {
+			table.source, err = expandColumnTypes(table.source, columnTypes)
 		}
-
-		err = compileLaTeX(tableTexFile, tablePdfFile)
 		if err != nil {
-			fmt.Printf("Error compiling LaTeX for %s: %v\n", filePath, err)
-		}
-		if !FolderExists(tablePdfFile) {
-			os.Remove(tableTexFile)
-			os.Remove(tmpLog)
-			os.Remove(tmpAux)
+			fmt.Printf("Error expanding macros of table %d in %s/%s: %v\n", i+1, paper.ID, filePath, err)
+			jobs = append(jobs, tableJob{paperID: paper.ID, filePath: paper.ID + "/" + filePath, index: i,
+				err: &Failure{Class: FailMacro, Err: err}})
 			continue
 		}
+		// remove tabs and spaces
+		table.source = removeTabs(table.source)
+		needed := addPackages(packages, filter.Filter(inferPackages(table)))
+		fullLatex := createFullLatexDocument(table, needed)
+		// the ground truth is the tabular the image is compiled from, without
+		// the caption and repeated heads of a longtable
+		jobs = append(jobs, tableJob{
+			paperID:   paper.ID,
+			filePath:  paper.ID + "/" + filePath,
+			index:     i,
+			baseName:  tableBaseName(paper, filePath),
+			table:     compileSource(table),
+			env:       table.env,
+			float:     table.float,
+			fullLatex: fullLatex,
+		})
+	}
+	return jobs
+}
 
-		pngFileName, err := convertPDFtoPNG(tablePdfFile, trainDataset)
-		if !isDebug {
-			os.Remove(tableTexFile)
-			os.Remove(tablePdfFile)
-			os.Remove(tmpLog)
-			os.Remove(tmpAux)
-		}
+// tableBaseName names the tables of a file after the paper, the directory of
+// the file and the file, so papers with the same sections/results.tex do not
+// overwrite each other's images.
+func tableBaseName(paper Paper, filePath string) string {
+	name := strings.TrimSuffix(filePath, path.Ext(filePath))
+	return paper.ID + "_" + strings.ReplaceAll(name, "/", "_")
+}
 
-		if err != nil {
-			fmt.Printf("Error converting PDF to PNG for %s: %v\n", filePath, err)
+// removeTabs drops the tabs of a table. A tab that is the only space after a
+// command becomes a space, \hline<tab>A must not turn into \hlineA.
+func removeTabs(table string) string {
+	var b strings.Builder
+	tokens := latex.Tokenize(table)
+	for i, tok := range tokens {
+		if tok.Kind != latex.Space {
+			b.WriteString(tok.Text)
 			continue
 		}
-		fmt.Printf("Table %d from %s converted to %s\n", i+1, filePath, trainDataset)
-
-		table = replace_norm(table)
-		newMetadata := Metadata{
-			FileName:    pngFileName,
-			GroundTruth: table,
+		space := strings.ReplaceAll(tok.Text, "\t", "")
+		if space == "" && i > 0 && tokens[i-1].Kind == latex.ControlWord && i+1 < len(tokens) &&
+			tokens[i+1].Kind == latex.Text && unicode.IsLetter(rune(tokens[i+1].Text[0])) {
+			space = " "
 		}
-		appendMetaInfo(newMetadata, trainDataset)
-
-		MAP_DATASET_COUNT[trainDataset]++
+		b.WriteString(space)
 	}
-	return true
+	return b.String()
 }
 
+// rowEnds are the plain TeX row ends written as \\ in the ground truth.
+var rowEnds = map[string]string{`\cr`: `\\`, `\crcr`: `\\`}
+
 func replace_norm(input string) string {
-	input = strings.ReplaceAll(input, "\\cr", "\\\\")
+	input = latex.ReplaceCommands(input, rowEnds)
 	input = strings.ReplaceAll(input, "\r", "")
 	// input = strings.ReplaceAll(input, "\n", "[NEWLINE]")
 	return input
@@ -267,7 +313,7 @@ func replace_latex(input string) string {
 	input = strings.ReplaceAll(input, "\\begin{tabular}", "<s_table>")
 	input = strings.ReplaceAll(input, "\\end{tabular}", "</s_table>")
 
-	input = strings.ReplaceAll(input, "\\cr", "\\\\")
+	input = latex.ReplaceCommands(input, rowEnds)
 	input = strings.ReplaceAll(input, "\r\n", "\n")
 	input = strings.ReplaceAll(input, "\n\r", "\n")
 	rows := strings.Split(input, "\n")
@@ -312,6 +358,10 @@ func replace_latex(input string) string {
 }
 
 func appendMetaInfo(newMetadata Metadata, bashpath string) {
+	// workers of all splits append concurrently
+	metaInfoMu.Lock()
+	defer metaInfoMu.Unlock()
+
 	// 打开文件，使用追加模式
 	file, err := os.OpenFile(filepath.Join(bashpath, "metadata.jsonl"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
@@ -344,92 +394,14 @@ func appendMetaInfo(newMetadata Metadata, bashpath string) {
 	fmt.Println("New data successfully appended to metadata.jsonl")
 }
 
-const maxRecursionDepth = 3
-
-func processInput(content string, basePath string, depth int) (result string, err error) {
-	if depth > maxRecursionDepth {
-		return content, fmt.Errorf("达到最大递归深度 %d", maxRecursionDepth)
-	}
-
-	inputRegex := regexp.MustCompile(`\\input\{([^}]+)\}`)
-
-	defer func() {
-		if r := recover(); r != nil {
-			err = fmt.Errorf("occur panic: %v", r)
-			result = ""
-		}
-	}()
-	processedContent := inputRegex.ReplaceAllStringFunc(content, func(match string) string {
-		filename := inputRegex.FindStringSubmatch(match)[1]
-		fullPath := filepath.Join(basePath, filename)
-
-		// 如果文件名没有扩展名，添加 .tex 扩展名
-		if filepath.Ext(fullPath) == "" {
-			fullPath += ".tex"
-		}
-
-		fileContent, err := loadFile(fullPath)
-		if err != nil {
-			fmt.Printf("警告：无法加载文件 %s: %v\n", fullPath, err)
-			return match // 如果无法加载文件，保留原始的 \input 命令
-		}
-
-		// 递归处理加载的文件中的 \input 命令
-		processedFileContent, _ := processInput(fileContent, filepath.Dir(fullPath), depth+1)
-		return processedFileContent
-	})
-
-	return processedContent, nil
-}
-
-func extractTables(content string) []string {
-	// 第一步：提取 \begin{table} 和 \end{table} 之间的内容
-	tableRe := regexp.MustCompile(`(?s)\\begin{table}(.*?)\\end{table}`)
-	tables := tableRe.FindAllStringSubmatch(content, -1)
-
-	// 第二步：从每个table中提取 \begin{tabular} 部分
-	tabularRe := regexp.MustCompile(`(?s)\\begin{tabular}.*?\\end{tabular}`)
-	var result []string
-
-	for _, table := range tables {
-		if len(table) > 1 {
-			tabular := tabularRe.FindString(table[1])
-			if tabular != "" {
-				result = append(result, tabular)
-			}
-		}
-	}
-
-	return result
-}
-
-func createFullLatexDocument(docHead string, table string) string {
-	macroStrings := strings.Split(strings.TrimSpace(docHead), "\n")
-	docHead = ""
-	macroMap := make(map[string]string)
-	commandMap := make(map[string]string)
-	for _, macroString := range macroStrings {
-		macroString = removeAfterPercent(macroString)
-		macroName, macroDef, commandName, commandDef := parseLaTeXMacro(macroString)
-		if macroName != "" && macroDef != "" {
-			macroMap[macroName] = macroDef
-			fmt.Printf("Macro added to map: %s -> %s\n", macroName, macroDef)
-		}
-		if commandName != "" && commandDef != "" {
-			commandMap[commandName] = commandDef
-			fmt.Printf("Command added to map: %s -> %s\n", commandName, commandDef)
-		}
-	}
-
-	if containCommand(table, commandMap) {
-		return ""
-	}
-	table = replaceMacro(table, macroMap)
+func createFullLatexDocument(texTable texTable, packages []Package) string {
+	docHead := tableDocHead(packages)
+	table := compileSource(texTable)
 
+	// comments were removed by preprocess, only the lines they leave empty go
 	realTable := ""
 	tmpTable := strings.Split(table, "\n")
 	for _, tableLine := range tmpTable {
-		tableLine = removeAfterPercent(tableLine)
 		if tableLine == "" {
 			continue
 		}
@@ -443,38 +415,62 @@ func createFullLatexDocument(docHead string, table string) string {
 	originalLatex := `\documentclass{standalone}
 ` + docHead + `
 \begin{document}
-` + realTable + `
+` + wrapFloat(texTable, realTable) + `
 \end{document}`
 
 	return originalLatex
 }
 
-func compileLaTeX(inputFile, outputFile string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// compileLaTeX runs pdflatex in the directory of inputFile, which is where
+// outputFile is written. pdflatex may only open files in that directory and
+// below it, a table that tries to read or write others is a FailPathViolation
+// whether pdflatex succeeded or not, and its pdf is removed.
+func compileLaTeX(parent context.Context, inputFile, outputFile string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(parent, timeout)
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, "pdflatex", "-interaction=nonstopmode", "-output-directory="+filepath.Dir(outputFile), inputFile)
+	if filepath.Dir(inputFile) != filepath.Dir(outputFile) {
+		return fmt.Errorf("pdf %s is not written next to %s", outputFile, inputFile)
+	}
+	cmd := exec.CommandContext(ctx, "pdflatex", "-interaction=nonstopmode", "-no-shell-escape", filepath.Base(inputFile))
+	cmd.Dir = filepath.Dir(inputFile)
+	cmd.Env = append(os.Environ(), pdflatexEnv...)
 
+	// the output is in the .log next to the pdf
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 
 	err := cmd.Run()
 
+	for _, output := range []string{stdout.String(), stderr.String()} {
+		for _, line := range strings.Split(output, "\n") {
+			for _, violation := range pathViolations {
+				if strings.Contains(line, violation) {
+					os.Remove(outputFile)
+					return &Failure{Class: FailPathViolation, Name: violatingPath(line), Err: errors.New(strings.TrimSpace(line))}
+				}
+			}
+		}
+	}
+
 	if err != nil {
 		if ctx.Err() == context.DeadlineExceeded {
-			return fmt.Errorf("LaTeX compilation timed out after 2 minutes")
+			return &Failure{Class: FailTimeout, Err: fmt.Errorf("LaTeX compilation timed out after %v", timeout)}
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("LaTeX compilation failed: %v: %s", err, msg)
 		}
-		return fmt.Errorf("LaTeX compilation failed: %v\nStdout: %s\nStderr: %s", err, stdout.String(), stderr.String())
+		return fmt.Errorf("LaTeX compilation failed: %v", err)
 	}
 
 	return nil
 }
 
-func convertPDFtoPNG(pdfFile, outputDir string) (string, error) {
+func convertPDFtoPNG(pdfFile, outputDir string, minWidth, minHeight int) (string, error) {
 	doc, err := fitz.New(pdfFile)
 	if err != nil {
-		return "", fmt.Errorf("error opening PDF: %v", err)
+		return "", &Failure{Class: FailRender, Err: fmt.Errorf("error opening PDF: %v", err)}
 	}
 	defer doc.Close()
 
@@ -485,13 +481,13 @@ func convertPDFtoPNG(pdfFile, outputDir string) (string, error) {
 
 	pngFileName := convertPNGName(pdfFile)
 	if doc.NumPage() > 1 {
-		return "", fmt.Errorf("PDF contains multiple pages")
+		return "", &Failure{Class: FailMultiPage, Err: fmt.Errorf("PDF contains %d pages", doc.NumPage())}
 	}
 
 	n := 0
 	img, err := doc.Image(n)
 	if err != nil {
-		return "", fmt.Errorf("error rendering page %d: %v", n+1, err)
+		return "", &Failure{Class: FailRender, Err: fmt.Errorf("error rendering page %d: %v", n+1, err)}
 	}
 
 	// check image size
@@ -499,10 +495,9 @@ func convertPDFtoPNG(pdfFile, outputDir string) (string, error) {
 	width := bounds.Max.X - bounds.Min.X
 	height := bounds.Max.Y - bounds.Min.Y
 
-	// set min image size
-	minWidth, minHeight := 100, 100
 	if width < minWidth || height < minHeight {
-		return "", fmt.Errorf("image size too small: %dx%d (minimum required: %dx%d)", width, height, minWidth, minHeight)
+		return "", &Failure{Class: FailTooSmall,
+			Err: fmt.Errorf("image size too small: %dx%d (minimum required: %dx%d)", width, height, minWidth, minHeight)}
 	}
 
 	outFile := filepath.Join(outputDir, pngFileName)
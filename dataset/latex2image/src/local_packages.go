@@ -0,0 +1,132 @@
+package src
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"latex2image/src/latex"
+)
+
+// packageExts are the extensions of the files the commands load. A paper may
+// ship them instead of relying on TeX Live.
+var packageExts = map[string]string{
+	`\usepackage`:     ".sty",
+	`\RequirePackage`: ".sty",
+	`\documentclass`:  ".cls",
+	`\LoadClass`:      ".cls",
+}
+
+// maxPackageDepth bounds how deep local packages may load each other.
+const maxPackageDepth = 8
+
+// localPackage is a .sty or .cls file of a paper.
+type localPackage struct {
+	// name as TeX looks it up, relative to the directory of the root, e.g.
+	// sty/macros.sty for \usepackage{sty/macros}
+	name    string
+	content []byte
+	// the packages it loads as \usepackage and its definitions
+	preamble string
+}
+
+// loadLocalPackages returns the packages and classes of the paper that the
+// preamble of src loads, looked up in dir like pdflatex started there would,
+// and those they load in turn. A package comes after the packages it loads.
+func loadLocalPackages(fsys fs.FS, dir, src string) []*localPackage {
+	var packages []*localPackage
+	seen := make(map[string]bool)
+	var load func(src string, parse func(string) (*latex.Document, error), depth int)
+	load = func(src string, parse func(string) (*latex.Document, error), depth int) {
+		if depth > maxPackageDepth {
+			return
+		}
+		doc, _ := parse(src)
+		if end := beginDocument(doc.Tokens); end >= 0 {
+			doc, _ = parse(src[:end])
+		}
+		for _, name := range loadedFiles(doc) {
+			file := path.Join(dir, name)
+			if seen[file] || !fs.ValidPath(name) || !fs.ValidPath(file) {
+				continue
+			}
+			seen[file] = true
+			content, err := fs.ReadFile(fsys, file)
+			if err != nil {
+				// a package of TeX Live
+				continue
+			}
+			clean := preprocessPackage(string(content)).text
+			load(clean, latex.ParsePackage, depth+1)
+			packages = append(packages, &localPackage{name: name, content: content, preamble: packagePreamble(clean)})
+		}
+	}
+	load(preprocess(src).text, latex.Parse, 0)
+	return packages
+}
+
+// loadedFiles returns the files of the packages and classes doc loads, with
+// their extension.
+func loadedFiles(doc *latex.Document) []string {
+	var names []string
+	commands := []string{`\usepackage`, `\RequirePackage`, `\documentclass`, `\LoadClass`}
+	visitCommands(doc.Nodes, commands, func(nodes []latex.Node, i int) int {
+		_, next, _ := latex.NextOptionalArg(nodes, i+1)
+		list, next, ok := latex.NextArg(nodes, next)
+		if !ok || list.Start == list.BodyStart {
+			return i
+		}
+		for _, name := range splitList(doc.Uncommented(list.BodyStart, list.BodyEnd)) {
+			names = append(names, name+packageExts[nodes[i].Token.Text])
+		}
+		return next
+	})
+	return names
+}
+
+// packagePreamble returns the packages a package loads and its definitions, in
+// a form ExtractPreamble could have returned.
+func packagePreamble(src string) string {
+	statements := findStatements(`\makeatletter `+src, append([]string{`\RequirePackage`}, definitionCommands...)...)
+	if len(statements) == 1 {
+		// only the \makeatletter
+		return ""
+	}
+	for i, statement := range statements {
+		if rest, ok := strings.CutPrefix(statement, `\RequirePackage`); ok {
+			statements[i] = `\usepackage` + rest
+		}
+	}
+	return strings.Join(append(statements, `\makeatother`), "\n")
+}
+
+// localPreamble puts the preambles of the local packages of a paper in front of
+// its own preamble, the packages are loaded before the paper defines its
+// macros.
+func localPreamble(packages []*localPackage, preamble string) string {
+	var parts []string
+	for _, pkg := range packages {
+		if pkg.preamble != "" {
+			parts = append(parts, pkg.preamble)
+		}
+	}
+	return strings.Join(append(parts, preamble), "\n")
+}
+
+// writeLocalPackages copies the local packages of a paper into dir, where
+// pdflatex finds them first.
+func writeLocalPackages(dir string, packages []*localPackage) error {
+	for _, pkg := range packages {
+		file := filepath.Join(dir, filepath.FromSlash(pkg.name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(file, pkg.content, 0644); err != nil {
+			return fmt.Errorf("writing %s: %w", pkg.name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,406 @@
+package macro
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"latex2image/src/latex"
+)
+
+// Expand replaces every macro of s in src by its body with the arguments filled
+// in, until no macro is left. Only whole control words match, so \a does not
+// touch \alpha. Macros are expanded with their last definition. Like TeX reads
+// it, an expansion is read again together with the source after it, so a macro
+// at the end of a body takes its arguments from there. The names defined by
+// \newcommand, \def, \let and the like are not expanded.
+func (s *Set) Expand(src string) (string, error) {
+	e := &expander{set: s}
+	tokens, err := e.expand(latex.Tokenize(src))
+	if err != nil {
+		return "", err
+	}
+	return latex.Join(tokens), nil
+}
+
+type expander struct {
+	set   *Set
+	count int
+}
+
+// frame is an expansion that is not read completely yet, at the depth of the
+// macros it was expanded from.
+type frame struct {
+	tokens []latex.Token
+	depth  int
+	// an expansion merged with the tokens after it ends where outerText bytes
+	// of text are left, they are at outerDepth
+	outerText  int
+	outerDepth int
+}
+
+func (e *expander) expand(tokens []latex.Token) ([]latex.Token, error) {
+	var out []latex.Token
+	// the innermost expansion last
+	frames := []frame{{tokens: tokens}}
+	for len(frames) > 0 {
+		f := &frames[len(frames)-1]
+		if len(f.tokens) == 0 {
+			frames = frames[:len(frames)-1]
+			continue
+		}
+		tok := f.tokens[0]
+		if tok.Kind == latex.ControlWord && IsDefinition(tok.Text) {
+			n := DefinedNames(f.tokens)
+			out = append(out, f.tokens[:n]...)
+			f.tokens = f.tokens[n:]
+			continue
+		}
+		m, ok := e.lookup(tok)
+		if !ok {
+			out = append(out, tok)
+			f.tokens = f.tokens[1:]
+			continue
+		}
+		if f.outerText > 0 && textLen(f.tokens) <= f.outerText {
+			// read past the merged expansion
+			f.depth, f.outerText = f.outerDepth, 0
+		}
+		if f.depth >= MaxDepth {
+			return nil, fmt.Errorf("%v: %s: %w", tok.Pos, tok.Text, ErrDepth)
+		}
+
+		// the arguments of a macro ending an expansion follow it
+		for len(frames) > 1 && takesArgs(m) && len(skipSpace(f.tokens[1:])) == 0 {
+			frames = mergeFrames(frames)
+			f = &frames[len(frames)-1]
+		}
+		args, rest, err := readArgs(afterName(f.tokens), m)
+		for err != nil && len(frames) > 1 {
+			frames = mergeFrames(frames)
+			f = &frames[len(frames)-1]
+			args, rest, err = readArgs(afterName(f.tokens), m)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%v: %s: %w", tok.Pos, tok.Text, err)
+		}
+		f.tokens = rest
+
+		if m.builtin {
+			out = append(out, m.body...)
+			continue
+		}
+		expanded := substitute(m.body, args)
+		e.count += len(expanded)
+		if e.count > maxTokens {
+			return nil, fmt.Errorf("%v: %s: expansion too large", tok.Pos, tok.Text)
+		}
+		frames = append(frames, frame{tokens: expanded, depth: f.depth + 1})
+	}
+	return out, nil
+}
+
+// mergeFrames joins the innermost expansion with the tokens after it. The
+// tokens after it keep their depth.
+func mergeFrames(frames []frame) []frame {
+	inner, outer := frames[len(frames)-1], frames[len(frames)-2]
+	merged := frame{
+		tokens:     append(slices.Clip(inner.tokens), outer.tokens...),
+		depth:      inner.depth,
+		outerText:  textLen(outer.tokens),
+		outerDepth: outer.depth,
+	}
+	if outer.outerText > 0 {
+		// the merged part of the outer frame counts as the expansion
+		merged.outerText, merged.outerDepth = outer.outerText, outer.outerDepth
+	}
+	return append(frames[:len(frames)-2], merged)
+}
+
+func textLen(tokens []latex.Token) int {
+	n := 0
+	for _, tok := range tokens {
+		n += len(tok.Text)
+	}
+	return n
+}
+
+func takesArgs(m *Macro) bool {
+	return m.NArgs > 0 || m.HasDefault || len(m.prefix) > 0
+}
+
+// DefinedNames returns the number of tokens of the definition command at
+// tokens[0] and the names it defines, which are kept as they are: the name of
+// \newcommand{\name} and \def\name, and both of \let\name=\other.
+func DefinedNames(tokens []latex.Token) int {
+	i := 1
+	next := func() {
+		for i < len(tokens) && (tokens[i].Kind == latex.Space || tokens[i].Kind == latex.Comment) {
+			i++
+		}
+	}
+	next()
+	switch tokens[0].Text {
+	case `\def`, `\gdef`, `\edef`, `\xdef`:
+		return min(i+1, len(tokens))
+	case `\let`:
+		i++
+		next()
+		if i < len(tokens) && tokens[i].Kind == latex.Other && tokens[i].Text == "=" {
+			i++
+			next()
+		}
+		return min(i+1, len(tokens))
+	}
+	if i < len(tokens) && tokens[i].Kind == latex.Other && tokens[i].Text == "*" {
+		i++
+		next()
+	}
+	if i < len(tokens) && tokens[i].Kind == latex.BeginGroup {
+		if end, ok := groupEnd(tokens[i:]); ok {
+			return i + end + 1
+		}
+	}
+	return min(i+1, len(tokens))
+}
+
+func (e *expander) lookup(tok latex.Token) (*Macro, bool) {
+	if tok.Kind != latex.ControlWord && tok.Kind != latex.ControlSymbol {
+		return nil, false
+	}
+	return e.set.Lookup(tok.Text)
+}
+
+// afterName returns the tokens after the macro at tokens[0]. TeX drops the
+// spaces after a control word, so they are neither output nor the start of a
+// delimited argument.
+func afterName(tokens []latex.Token) []latex.Token {
+	rest := tokens[1:]
+	if tokens[0].Kind == latex.ControlWord && len(rest) > 0 && isSkippedSpace(rest[0]) {
+		return rest[1:]
+	}
+	return rest
+}
+
+// isSkippedSpace reports whether tok is a space TeX skips, a blank line is a
+// paragraph and stays.
+func isSkippedSpace(tok latex.Token) bool {
+	return tok.Kind == latex.Space && strings.Count(tok.Text, "\n") < 2
+}
+
+// readArgs reads the arguments of m from the start of tokens. It returns them
+// without their braces and the tokens after the last one.
+func readArgs(tokens []latex.Token, m *Macro) ([][]latex.Token, []latex.Token, error) {
+	if m.ParamText != "" {
+		return matchParams(tokens, m)
+	}
+	args := make([][]latex.Token, 0, m.NArgs)
+	if m.HasDefault {
+		arg, rest, ok := readOptional(tokens)
+		if ok {
+			tokens = rest
+		} else {
+			arg = latex.Tokenize(m.Default)
+		}
+		args = append(args, arg)
+	}
+	for len(args) < m.NArgs {
+		arg, rest, ok := readArg(tokens)
+		if !ok {
+			return nil, tokens, fmt.Errorf("missing argument %d", len(args)+1)
+		}
+		args = append(args, arg)
+		tokens = rest
+	}
+	return args, tokens, nil
+}
+
+// matchParams reads the arguments of a \def following its parameter text: the
+// text before #1 has to be there, a parameter followed by a delimiter takes
+// everything up to the delimiter.
+func matchParams(tokens []latex.Token, m *Macro) ([][]latex.Token, []latex.Token, error) {
+	if len(m.prefix) > 0 {
+		tokens = explode(skipSpace(tokens))
+		if !hasPrefix(tokens, m.prefix) {
+			return nil, tokens, fmt.Errorf("use does not match the definition")
+		}
+		tokens = tokens[len(m.prefix):]
+	}
+
+	args := make([][]latex.Token, 0, len(m.delimiters))
+	for _, delimiter := range m.delimiters {
+		var arg []latex.Token
+		var ok bool
+		if len(delimiter) == 0 {
+			arg, tokens, ok = readArg(tokens)
+		} else {
+			arg, tokens, ok = readDelimited(explode(tokens), delimiter)
+		}
+		if !ok {
+			return nil, tokens, fmt.Errorf("missing argument %d", len(args)+1)
+		}
+		args = append(args, arg)
+	}
+	return args, tokens, nil
+}
+
+// readDelimited reads up to delimiter outside of braces. Like TeX it removes the
+// braces around an argument that is a single group.
+func readDelimited(tokens []latex.Token, delimiter []latex.Token) ([]latex.Token, []latex.Token, bool) {
+	depth := 0
+	for j := range tokens {
+		if depth == 0 && hasPrefix(tokens[j:], delimiter) {
+			return stripBraces(tokens[:j]), tokens[j+len(delimiter):], true
+		}
+		switch tokens[j].Kind {
+		case latex.BeginGroup:
+			depth++
+		case latex.EndGroup:
+			depth--
+			if depth < 0 {
+				return nil, tokens, false
+			}
+		}
+	}
+	return nil, tokens, false
+}
+
+// stripBraces removes the braces of a delimited argument that is a single
+// group, like TeX does.
+func stripBraces(arg []latex.Token) []latex.Token {
+	if len(arg) > 1 && arg[0].Kind == latex.BeginGroup {
+		if end, ok := groupEnd(arg); ok && end == len(arg)-1 {
+			return arg[1:end]
+		}
+	}
+	return arg
+}
+
+// hasPrefix compares tokens by kind and text, spaces of any length are equal.
+func hasPrefix(tokens []latex.Token, prefix []latex.Token) bool {
+	if len(tokens) < len(prefix) {
+		return false
+	}
+	for i, want := range prefix {
+		got := tokens[i]
+		if got.Kind != want.Kind || got.Kind != latex.Space && got.Text != want.Text {
+			return false
+		}
+	}
+	return true
+}
+
+// explode splits text tokens into single characters.
+func explode(tokens []latex.Token) []latex.Token {
+	out := make([]latex.Token, 0, len(tokens))
+	for _, tok := range tokens {
+		for tok.Kind == latex.Text {
+			_, size := utf8.DecodeRuneInString(tok.Text)
+			if size == len(tok.Text) {
+				break
+			}
+			var first latex.Token
+			first, tok = splitToken(tok, size)
+			out = append(out, first)
+		}
+		out = append(out, tok)
+	}
+	return out
+}
+
+func skipSpace(tokens []latex.Token) []latex.Token {
+	for len(tokens) > 0 && (tokens[0].Kind == latex.Space || tokens[0].Kind == latex.Comment) {
+		tokens = tokens[1:]
+	}
+	return tokens
+}
+
+// readArg reads a group or a single token; of a text token only the first
+// character is an argument, like \frac12.
+func readArg(tokens []latex.Token) ([]latex.Token, []latex.Token, bool) {
+	tokens = skipSpace(tokens)
+	if len(tokens) == 0 {
+		return nil, tokens, false
+	}
+	tok := tokens[0]
+	switch tok.Kind {
+	case latex.BeginGroup:
+		end, ok := groupEnd(tokens)
+		if !ok {
+			return nil, tokens, false
+		}
+		return tokens[1:end], tokens[end+1:], true
+	case latex.EndGroup:
+		return nil, tokens, false
+	case latex.Text:
+		if _, size := utf8.DecodeRuneInString(tok.Text); size < len(tok.Text) {
+			first, rest := splitToken(tok, size)
+			return []latex.Token{first}, append([]latex.Token{rest}, tokens[1:]...), true
+		}
+	}
+	return tokens[:1], tokens[1:], true
+}
+
+// groupEnd returns the index of the } closing the { at tokens[0].
+func groupEnd(tokens []latex.Token) (int, bool) {
+	depth := 0
+	for j := range tokens {
+		switch tokens[j].Kind {
+		case latex.BeginGroup:
+			depth++
+		case latex.EndGroup:
+			depth--
+			if depth == 0 {
+				return j, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// readOptional reads [...] up to the first ] outside of braces.
+func readOptional(tokens []latex.Token) ([]latex.Token, []latex.Token, bool) {
+	tokens = skipSpace(tokens)
+	if len(tokens) == 0 || tokens[0].Kind != latex.Other || tokens[0].Text != "[" {
+		return nil, tokens, false
+	}
+	depth := 0
+	for j := 1; j < len(tokens); j++ {
+		switch tok := tokens[j]; {
+		case tok.Kind == latex.BeginGroup:
+			depth++
+		case tok.Kind == latex.EndGroup:
+			depth--
+		case depth == 0 && tok.Kind == latex.Other && tok.Text == "]":
+			return stripBraces(tokens[1:j]), tokens[j+1:], true
+		}
+	}
+	return nil, tokens, false
+}
+
+func splitToken(tok latex.Token, size int) (latex.Token, latex.Token) {
+	first := latex.Token{Kind: tok.Kind, Text: tok.Text[:size], Pos: tok.Pos}
+	restPos := latex.Pos{Offset: tok.Pos.Offset + size, Line: tok.Pos.Line, Col: tok.Pos.Col + 1}
+	return first, latex.Token{Kind: tok.Kind, Text: tok.Text[size:], Pos: restPos}
+}
+
+// substitute fills #1..#9 of body with args, ## becomes #.
+func substitute(body []latex.Token, args [][]latex.Token) []latex.Token {
+	out := make([]latex.Token, 0, len(body))
+	for _, tok := range body {
+		if tok.Kind != latex.Parameter || len(tok.Text) != 2 {
+			out = append(out, tok)
+			continue
+		}
+		if tok.Text == "##" {
+			out = append(out, latex.Token{Kind: latex.Parameter, Text: "#", Pos: tok.Pos})
+			continue
+		}
+		n := int(tok.Text[1] - '1')
+		if n < len(args) {
+			out = append(out, args[n]...)
+		}
+	}
+	return out
+}
@@ -0,0 +1,94 @@
+package macro
+
+import (
+	"errors"
+	"testing"
+)
+
+func expand(t *testing.T, preamble, src string) (string, error) {
+	t.Helper()
+	s := NewSet()
+	if err := s.Collect(preamble); err != nil {
+		t.Fatalf("Collect(%q): %v", preamble, err)
+	}
+	return s.Expand(src)
+}
+
+func TestExpandDef(t *testing.T) {
+	tests := []struct {
+		preamble string
+		src      string
+		want     string
+	}{
+		// the space after a control word does not start a delimited argument
+		{`\def\foo#1.#2\relax{[#1|#2]}`, `\foo x.y\relax`, `[x|y]`},
+		{`\def\foo#1.#2\relax{[#1|#2]}`, `\foo x. y\relax`, `[x| y]`},
+		{`\def\foo#1.{[#1]}`, `\foo {a.b}.c`, `[a.b]c`},
+		{`\def\foo#1#2.{[#1|#2]}`, `\foo ab c.`, `[a|b c]`},
+		{`\def\q#1#2.{(#1#2)}`, `\q ab.c`, `(ab)c`},
+		{`\def\pair(#1,#2){#1+#2}`, `\pair (a,b) c`, `a+b c`},
+		{`\def\a{A}\let\b\a`, `\b\a`, `AA`},
+		{`\def\a{A}\edef\b{\a\a}\def\a{B}`, `\b`, `AA`},
+		{`\makeatletter\def\my@cmd{x}\def\cmd{\my@cmd}\makeatother`, `\cmd`, `x`},
+	}
+	for _, tt := range tests {
+		got, err := expand(t, tt.preamble, tt.src)
+		if err != nil || got != tt.want {
+			t.Errorf("%s: Expand(%q) = %q, %v, want %q", tt.preamble, tt.src, got, err, tt.want)
+		}
+	}
+}
+
+func TestExpandNewcommand(t *testing.T) {
+	tests := []struct {
+		preamble string
+		src      string
+		want     string
+	}{
+		{`\newcommand{\R}{\mathbb{R}}`, `$x\in\R$`, `$x\in\mathbb{R}$`},
+		{`\newcommand{\R}{\mathbb{R}}`, `\R x \Rn`, `\mathbb{R}x \Rn`},
+		{`\newcommand{\pair}[2]{(#1,#2)}`, `\pair{a}{b}`, `(a,b)`},
+		{`\newcommand{\pair}[2]{(#1,#2)}`, `\pair a {b c}`, `(a,b c)`},
+		{`\newcommand{\frc}[2]{#1/#2}`, `\frc12`, `1/2`},
+		{`\newcommand{\opt}[2][d]{#1:#2}`, `\opt{x} \opt[o]{y}`, `d:x o:y`},
+		{`\newcommand{\opt}[2][d]{#1:#2}`, `\opt[{a]}]{y}`, `a]:y`},
+		{`\newcommand*\best{\textbf}`, `\best{x}`, `\textbf{x}`},
+		// the expansion is read again with the tokens after it
+		{`\newcommand{\foo}{\best}\newcommand{\best}[1]{[#1]}`, `\foo{x}`, `[x]`},
+		{`\newcommand{\a}{\b}\newcommand{\b}{B}`, `\a`, `B`},
+		{`\newcommand{\twice}[1]{#1#1}`, `\twice{\twice{x}}`, `xxxx`},
+		// the first \newcommand wins, \renewcommand replaces it
+		{`\newcommand{\a}{1}\newcommand{\a}{2}`, `\a`, `1`},
+		{`\newcommand{\a}{1}\renewcommand{\a}{2}`, `\a`, `2`},
+		{`\newcommand{\a}{1}\providecommand{\a}{2}\providecommand{\b}{3}`, `\a\b`, `13`},
+		{`\DeclareMathOperator{\rank}{rank}\DeclareMathOperator*{\argmax}{arg\,max}`, `\rank\argmax`, `\operatorname{rank}\operatorname*{arg\,max}`},
+		// defined names are not expanded
+		{`\newcommand{\stretch}{2}`, `\renewcommand{\arraystretch}{\stretch}`, `\renewcommand{\arraystretch}{2}`},
+		{`\newcommand{\a}{A}`, `\renewcommand{\a}{B}\def\a{C}\let\a\relax`, `\renewcommand{\a}{B}\def\a{C}\let\a\relax`},
+	}
+	for _, tt := range tests {
+		got, err := expand(t, tt.preamble, tt.src)
+		if err != nil || got != tt.want {
+			t.Errorf("%s: Expand(%q) = %q, %v, want %q", tt.preamble, tt.src, got, err, tt.want)
+		}
+	}
+}
+
+func TestExpandErrors(t *testing.T) {
+	tests := []struct {
+		preamble string
+		src      string
+		err      error
+	}{
+		{`\newcommand{\loop}{\loop}`, `\loop`, ErrDepth},
+		{`\def\a{\b}\def\b{\a}`, `\a`, ErrDepth},
+		{`\newcommand{\pair}[2]{(#1,#2)}`, `\pair{a}`, nil},
+		{`\def\foo#1.{#1}`, `\foo x`, nil},
+	}
+	for _, tt := range tests {
+		_, err := expand(t, tt.preamble, tt.src)
+		if err == nil || tt.err != nil && !errors.Is(err, tt.err) {
+			t.Errorf("%s: Expand(%q) error = %v, want %v", tt.preamble, tt.src, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,343 @@
+// Package macro expands the commands authors define with \newcommand, \def,
+// \DeclareMathOperator and their variants, so a table can be compiled without
+// the preamble of its paper.
+package macro
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"latex2image/src/latex"
+)
+
+// MaxDepth bounds how deep macros may expand into other macros, recursive
+// definitions stop there.
+const MaxDepth = 32
+
+// maxTokens bounds the size of an expansion, macros that double their input at
+// every level would otherwise exhaust the memory before reaching MaxDepth.
+const maxTokens = 1 << 20
+
+var ErrDepth = errors.New("macro expansion too deep")
+
+type Macro struct {
+	// command name with its backslash
+	Name  string
+	NArgs int
+	// the first argument is optional and defaults to Default
+	HasDefault bool
+	Default    string
+	// parameter text of a \def, like #1#2 or #1.#2\relax
+	ParamText string
+	Body      string
+	body      []latex.Token
+	// tokens before #1 and after every parameter in the parameter text
+	prefix     []latex.Token
+	delimiters [][]latex.Token
+	// the body is a command the paper did not define, e.g. after \let\a\b, and
+	// is not expanded again
+	builtin bool
+	// defined after \makeatletter, @ is a letter in the body
+	atLetter bool
+}
+
+// Mode says what happens when a macro is defined twice.
+type Mode int
+
+const (
+	// \newcommand, the first definition wins like LaTeX refusing the second
+	ModeNew Mode = iota
+	// \renewcommand and \def, the last definition wins
+	ModeRenew
+	// \providecommand, only defines a macro that does not exist yet
+	ModeProvide
+)
+
+var definitionModes = map[string]Mode{
+	`\newcommand`:     ModeNew,
+	`\renewcommand`:   ModeRenew,
+	`\providecommand`: ModeProvide,
+	`\def`:            ModeRenew,
+	`\gdef`:           ModeRenew,
+	`\edef`:           ModeRenew,
+	`\xdef`:           ModeRenew,
+	`\let`:            ModeRenew,
+	// amsmath refuses to declare an operator twice
+	`\DeclareMathOperator`: ModeNew,
+}
+
+// Set holds the macros of a paper.
+type Set struct {
+	macros map[string]*Macro
+}
+
+func NewSet() *Set {
+	return &Set{macros: make(map[string]*Macro)}
+}
+
+func (s *Set) Len() int {
+	return len(s.macros)
+}
+
+func (s *Set) Lookup(name string) (*Macro, bool) {
+	m, ok := s.macros[name]
+	return m, ok
+}
+
+// Define adds m. With ModeNew and ModeProvide an existing macro is kept.
+func (s *Set) Define(m *Macro, mode Mode) {
+	if _, exists := s.macros[m.Name]; exists && mode != ModeRenew {
+		return
+	}
+	tokenize := latex.Tokenize
+	if m.atLetter {
+		tokenize = latex.TokenizePackage
+	}
+	m.body = tokenize(m.Body)
+	if m.ParamText != "" {
+		m.prefix, m.delimiters = splitParamText(tokenize(m.ParamText))
+	}
+	s.macros[m.Name] = m
+}
+
+// IsDefinition reports whether name starts a macro definition.
+func IsDefinition(name string) bool {
+	_, ok := definitionModes[name]
+	return ok
+}
+
+// ParseDefinition reads the definition at nodes[i] of doc. It returns the
+// macro, how it is defined and the index of the node after the definition.
+// \edef and \let depend on the macros defined so far, which s holds.
+func (s *Set) ParseDefinition(doc *latex.Document, nodes []latex.Node, i int) (*Macro, Mode, int, error) {
+	n := &nodes[i]
+	mode, ok := definitionModes[n.Token.Text]
+	if !ok || n.Kind != latex.TokenNode {
+		return nil, 0, i, fmt.Errorf("%v: not a macro definition", n.Pos())
+	}
+	switch n.Token.Text {
+	case `\def`, `\gdef`, `\edef`, `\xdef`:
+		m, next, err := s.parseDef(doc, nodes, i)
+		return m, mode, next, err
+	case `\let`:
+		m, next, err := s.parseLet(doc, nodes, i)
+		return m, mode, next, err
+	case `\DeclareMathOperator`:
+		m, next, err := parseMathOperator(doc, nodes, i)
+		return m, mode, next, err
+	}
+	m, next, err := parseNewcommand(doc, nodes, i)
+	return m, mode, next, err
+}
+
+func parseNewcommand(doc *latex.Document, nodes []latex.Node, i int) (*Macro, int, error) {
+	n := &nodes[i]
+	next, _ := latex.NextStar(nodes, i+1)
+	name, next, ok := latex.NextArg(nodes, next)
+	if !ok {
+		return nil, i, fmt.Errorf("%v: %s without a name", n.Pos(), n.Token.Text)
+	}
+	m := &Macro{Name: strings.TrimSpace(doc.Uncommented(name.BodyStart, name.BodyEnd))}
+	if !strings.HasPrefix(m.Name, `\`) {
+		return nil, i, fmt.Errorf("%v: %s of %q, which is no command", n.Pos(), n.Token.Text, m.Name)
+	}
+
+	if nargs, after, ok := latex.NextOptionalArg(nodes, next); ok {
+		count, err := strconv.Atoi(strings.TrimSpace(doc.Source[nargs.BodyStart:nargs.BodyEnd]))
+		if err != nil || count < 0 || count > 9 {
+			return nil, i, fmt.Errorf("%v: %s has a bad argument count", n.Pos(), m.Name)
+		}
+		m.NArgs = count
+		next = after
+		if def, after, ok := latex.NextOptionalArg(nodes, next); ok {
+			m.HasDefault = true
+			m.Default = doc.Uncommented(def.BodyStart, def.BodyEnd)
+			next = after
+		}
+	}
+	if m.HasDefault && m.NArgs == 0 {
+		return nil, i, fmt.Errorf("%v: %s has a default but no arguments", n.Pos(), m.Name)
+	}
+
+	body, next, ok := latex.NextArg(nodes, next)
+	if !ok {
+		return nil, i, fmt.Errorf("%v: %s without a body", n.Pos(), m.Name)
+	}
+	m.Body = doc.Uncommented(body.BodyStart, body.BodyEnd)
+	return m, next, nil
+}
+
+// parseMathOperator reads \DeclareMathOperator{\name}{text}, which stands for
+// \operatorname{text}. The starred form puts limits below the operator.
+func parseMathOperator(doc *latex.Document, nodes []latex.Node, i int) (*Macro, int, error) {
+	n := &nodes[i]
+	next, star := latex.NextStar(nodes, i+1)
+	name, next, ok := latex.NextArg(nodes, next)
+	if !ok {
+		return nil, i, fmt.Errorf("%v: %s without a name", n.Pos(), n.Token.Text)
+	}
+	m := &Macro{Name: strings.TrimSpace(doc.Uncommented(name.BodyStart, name.BodyEnd))}
+	if !strings.HasPrefix(m.Name, `\`) {
+		return nil, i, fmt.Errorf("%v: %s of %q, which is no command", n.Pos(), n.Token.Text, m.Name)
+	}
+	text, next, ok := latex.NextArg(nodes, next)
+	if !ok {
+		return nil, i, fmt.Errorf("%v: %s without a text", n.Pos(), m.Name)
+	}
+	operator := `\operatorname`
+	if star {
+		operator += "*"
+	}
+	m.Body = operator + "{" + doc.Uncommented(text.BodyStart, text.BodyEnd) + "}"
+	return m, next, nil
+}
+
+// DefParts returns the indexes of the name and the body group of the \def at
+// nodes[i]. The parameter text lies between them.
+func DefParts(nodes []latex.Node, i int) (int, int, bool) {
+	name := latex.SkipSpace(nodes, i+1)
+	if name >= len(nodes) || !isCommandToken(&nodes[name]) {
+		return 0, 0, false
+	}
+	for body := name + 1; body < len(nodes); body++ {
+		if nodes[body].Kind == latex.GroupNode {
+			return name, body, true
+		}
+		if nodes[body].Kind == latex.EnvNode {
+			break
+		}
+	}
+	return 0, 0, false
+}
+
+func (s *Set) parseDef(doc *latex.Document, nodes []latex.Node, i int) (*Macro, int, error) {
+	n := &nodes[i]
+	name, body, ok := DefParts(nodes, i)
+	if !ok {
+		return nil, i, fmt.Errorf("%v: %s without a name or body", n.Pos(), n.Token.Text)
+	}
+	m := &Macro{
+		Name:      nodes[name].Token.Text,
+		ParamText: doc.Uncommented(nodes[name].End, nodes[body].Start),
+		Body:      doc.Uncommented(nodes[body].BodyStart, nodes[body].BodyEnd),
+	}
+	prefix, delimiters := splitParamText(latex.Tokenize(m.ParamText))
+	m.NArgs = len(delimiters)
+	if len(prefix) == 0 && m.NArgs == 0 {
+		// only spaces between name and body
+		m.ParamText = ""
+	}
+
+	// \edef and \xdef expand their body right away
+	if n.Token.Text == `\edef` || n.Token.Text == `\xdef` {
+		if expanded, err := s.Expand(m.Body); err == nil {
+			m.Body = expanded
+		}
+	}
+	return m, body + 1, nil
+}
+
+// LetEnd returns the index of the node after the \let at nodes[i]: the name, an
+// optional = and the token the name is set to.
+func LetEnd(nodes []latex.Node, i int) (int, int, bool) {
+	name := latex.SkipSpace(nodes, i+1)
+	if name >= len(nodes) || !isCommandToken(&nodes[name]) {
+		return 0, 0, false
+	}
+	target := latex.SkipSpace(nodes, name+1)
+	if target < len(nodes) && nodes[target].Kind == latex.TokenNode &&
+		nodes[target].Token.Kind == latex.Other && nodes[target].Token.Text == "=" {
+		target = latex.SkipSpace(nodes, target+1)
+	}
+	if target >= len(nodes) || nodes[target].Kind != latex.TokenNode {
+		return 0, 0, false
+	}
+	return name, target, true
+}
+
+func (s *Set) parseLet(doc *latex.Document, nodes []latex.Node, i int) (*Macro, int, error) {
+	name, target, ok := LetEnd(nodes, i)
+	if !ok {
+		return nil, i, fmt.Errorf("%v: \\let without a name or target", nodes[i].Pos())
+	}
+	tok := nodes[target].Token
+	if existing, ok := s.macros[tok.Text]; ok && isCommandToken(&nodes[target]) {
+		m := *existing
+		m.Name = nodes[name].Token.Text
+		return &m, target + 1, nil
+	}
+	text := tok.Text
+	if tok.Kind == latex.Text {
+		// \let only takes the first character
+		text = string([]rune(text)[:1])
+	}
+	return &Macro{Name: nodes[name].Token.Text, Body: text, builtin: true}, target + 1, nil
+}
+
+func isCommandToken(n *latex.Node) bool {
+	return n.Kind == latex.TokenNode && (n.Token.Kind == latex.ControlWord || n.Token.Kind == latex.ControlSymbol)
+}
+
+// splitParamText splits the parameter text of a \def into the tokens before #1
+// and the delimiter after every parameter. Text is split into characters,
+// because a delimiter may end in the middle of a word.
+func splitParamText(tokens []latex.Token) ([]latex.Token, [][]latex.Token) {
+	tokens = explode(tokens)
+	var prefix []latex.Token
+	var delimiters [][]latex.Token
+	for _, tok := range tokens {
+		switch {
+		case tok.Kind == latex.Parameter && len(tok.Text) == 2 && tok.Text != "##":
+			delimiters = append(delimiters, nil)
+		case len(delimiters) == 0:
+			prefix = append(prefix, tok)
+		default:
+			delimiters[len(delimiters)-1] = append(delimiters[len(delimiters)-1], tok)
+		}
+	}
+	// spaces after the name are skipped by TeX
+	for len(prefix) > 0 && prefix[0].Kind == latex.Space {
+		prefix = prefix[1:]
+	}
+	return prefix, delimiters
+}
+
+// Collect defines every macro defined in src, in order. Definitions that cannot
+// be read are returned in the error, the others are defined anyway.
+func (s *Set) Collect(src string) error {
+	doc, _ := latex.Parse(src)
+	var errs []error
+	atLetter := false
+	var visit func(nodes []latex.Node)
+	visit = func(nodes []latex.Node) {
+		for i := 0; i < len(nodes); i++ {
+			n := &nodes[i]
+			if n.Kind != latex.TokenNode {
+				visit(n.Children)
+				continue
+			}
+			switch {
+			case n.IsCommand(`\makeatletter`):
+				atLetter = true
+				continue
+			case n.IsCommand(`\makeatother`):
+				atLetter = false
+				continue
+			case !IsDefinition(n.Token.Text):
+				continue
+			}
+			m, mode, next, err := s.ParseDefinition(doc, nodes, i)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			m.atLetter = m.atLetter || atLetter
+			s.Define(m, mode)
+			i = next - 1
+		}
+	}
+	visit(doc.Nodes)
+	return errors.Join(errs...)
+}
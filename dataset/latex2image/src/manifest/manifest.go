@@ -0,0 +1,196 @@
+package manifest
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+)
+
+// File is one <file> entry of arXiv_src_manifest.xml.
+type File struct {
+	ContentMD5 string `xml:"content_md5sum"`
+	Filename   string `xml:"filename"`
+	FirstItem  string `xml:"first_item"`
+	LastItem   string `xml:"last_item"`
+	MD5        string `xml:"md5sum"`
+	NumItems   int    `xml:"num_items"`
+	SeqNum     int    `xml:"seq_num"`
+	Size       int64  `xml:"size"`
+	Timestamp  string `xml:"timestamp"`
+	YYMM       string `xml:"yymm"`
+}
+
+// Name returns the tar name without the src/ prefix, e.g. arXiv_src_0001_001.tar.
+func (f File) Name() string {
+	return path.Base(f.Filename)
+}
+
+type Manifest struct {
+	Files []File `xml:"file"`
+}
+
+func Load(manifestFile string) (*Manifest, error) {
+	file, err := os.Open(manifestFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return Parse(file)
+}
+
+func Parse(r io.Reader) (*Manifest, error) {
+	var m Manifest
+	if err := xml.NewDecoder(r).Decode(&m); err != nil {
+		return nil, fmt.Errorf("error parsing manifest: %v", err)
+	}
+	return &m, nil
+}
+
+// Lookup finds a file by its tar name.
+func (m *Manifest) Lookup(name string) (File, bool) {
+	for _, f := range m.Files {
+		if f.Name() == name {
+			return f, true
+		}
+	}
+	return File{}, false
+}
+
+// Selection picks tars by month and sequence number. Empty fields match all.
+type Selection struct {
+	// inclusive yymm range, e.g. 0704 to 2312
+	FromYYMM string
+	ToYYMM   string
+	SeqNums  []int
+}
+
+func (m *Manifest) Select(sel Selection) ([]File, error) {
+	from, to := -1, -1
+	var err error
+	if sel.FromYYMM != "" {
+		if from, err = yymmKey(sel.FromYYMM); err != nil {
+			return nil, err
+		}
+	}
+	if sel.ToYYMM != "" {
+		if to, err = yymmKey(sel.ToYYMM); err != nil {
+			return nil, err
+		}
+	}
+	seqNums := make(map[int]bool)
+	for _, seqNum := range sel.SeqNums {
+		seqNums[seqNum] = true
+	}
+
+	var files []File
+	for _, f := range m.Files {
+		key, err := yymmKey(f.YYMM)
+		if err != nil {
+			continue
+		}
+		if (from >= 0 && key < from) || (to >= 0 && key > to) {
+			continue
+		}
+		if len(seqNums) > 0 && !seqNums[f.SeqNum] {
+			continue
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// yymmKey turns yymm into a sortable yyyymm. arXiv starts in 1991, so 91-99
+// belong to the 1900s.
+func yymmKey(yymm string) (int, error) {
+	if len(yymm) != 4 {
+		return 0, fmt.Errorf("invalid yymm %q", yymm)
+	}
+	yy, err := strconv.Atoi(yymm[:2])
+	if err != nil {
+		return 0, fmt.Errorf("invalid yymm %q", yymm)
+	}
+	mm, err := strconv.Atoi(yymm[2:])
+	if err != nil || mm < 1 || mm > 12 {
+		return 0, fmt.Errorf("invalid yymm %q", yymm)
+	}
+	year := 2000 + yy
+	if yy >= 91 {
+		year = 1900 + yy
+	}
+	return year*100 + mm, nil
+}
+
+type Status int
+
+const (
+	StatusOK Status = iota
+	StatusMissing
+	StatusSizeMismatch
+	StatusChecksumMismatch
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusOK:
+		return "ok"
+	case StatusMissing:
+		return "missing"
+	case StatusSizeMismatch:
+		return "size mismatch"
+	case StatusChecksumMismatch:
+		return "checksum mismatch"
+	default:
+		return "unknown"
+	}
+}
+
+// Verify checks the tar of f in dir against the manifest size and, with
+// checksum, against its md5sum.
+func (f File) Verify(dir string, checksum bool) (Status, error) {
+	return VerifyFile(f, filepath.Join(dir, f.Name()), checksum)
+}
+
+func VerifyFile(f File, localPath string, checksum bool) (Status, error) {
+	info, err := os.Stat(localPath)
+	if os.IsNotExist(err) {
+		return StatusMissing, nil
+	}
+	if err != nil {
+		return StatusMissing, err
+	}
+	if info.Size() != f.Size {
+		return StatusSizeMismatch, nil
+	}
+	if !checksum {
+		return StatusOK, nil
+	}
+
+	sum, err := MD5File(localPath)
+	if err != nil {
+		return StatusChecksumMismatch, err
+	}
+	if sum != f.MD5 {
+		return StatusChecksumMismatch, nil
+	}
+	return StatusOK, nil
+}
+
+func MD5File(localPath string) (string, error) {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := md5.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
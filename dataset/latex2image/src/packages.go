@@ -0,0 +1,118 @@
+package src
+
+import (
+	"slices"
+	"strings"
+
+	"latex2image/src/latex"
+)
+
+// DefaultAllowPackages are packages that only add table, math, color and font
+// commands and compile fine in a standalone document.
+var DefaultAllowPackages = []string{
+	"amsmath", "amssymb", "amsfonts", "amstext", "mathtools", "bm", "bbm", "dsfont", "mathrsfs", "upgreek",
+	"array", "booktabs", "multirow", "makecell", "tabularx", "tabulary", "threeparttable", "dcolumn",
+	"hhline", "arydshln", "diagbox", "siunitx", "bigstrut", "bigdelim",
+	"xcolor", "color", "colortbl", "graphicx", "adjustbox",
+	"pifont", "wasysym", "textcomp", "gensymb", "marvosym", "fontawesome", "fontawesome5",
+	"inputenc", "fontenc", "times", "mathptmx", "newtxtext", "newtxmath", "lmodern", "helvet", "courier",
+	"soul", "ulem", "xspace", "relsize",
+}
+
+// DefaultDenyPackages change the page layout or need files and runs the
+// standalone document does not have.
+var DefaultDenyPackages = []string{
+	"geometry", "fullpage", "fancyhdr", "titlesec", "hyperref", "cleveref", "natbib", "biblatex", "cite",
+	"lineno", "showframe", "pdfpages", "tikz", "pgfplots", "minted",
+}
+
+// Package is a \usepackage of the paper.
+type Package struct {
+	Name    string
+	Options []string
+}
+
+func (p Package) String() string {
+	if len(p.Options) == 0 {
+		return `\usepackage{` + p.Name + `}`
+	}
+	return `\usepackage[` + strings.Join(p.Options, ",") + `]{` + p.Name + `}`
+}
+
+// collectPackages returns the packages loaded by \usepackage in preamble in
+// order. The options of a package loaded twice are merged.
+func collectPackages(preamble string) []Package {
+	doc, _ := latex.Parse(preamble)
+	var packages []Package
+	index := make(map[string]int)
+	visitCommands(doc.Nodes, []string{`\usepackage`}, func(nodes []latex.Node, i int) int {
+		var options []string
+		opts, next, ok := latex.NextOptionalArg(nodes, i+1)
+		if ok {
+			options = splitList(doc.Uncommented(opts.BodyStart, opts.BodyEnd))
+		}
+		names, next, ok := latex.NextArg(nodes, next)
+		if !ok || names.Start == names.BodyStart {
+			return i
+		}
+		for _, name := range splitList(doc.Uncommented(names.BodyStart, names.BodyEnd)) {
+			if j, loaded := index[name]; loaded {
+				for _, option := range options {
+					if !slices.Contains(packages[j].Options, option) {
+						packages[j].Options = append(packages[j].Options, option)
+					}
+				}
+				continue
+			}
+			index[name] = len(packages)
+			packages = append(packages, Package{Name: name, Options: slices.Clone(options)})
+		}
+		return next
+	})
+	return packages
+}
+
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// PackageFilter decides which packages of a paper go into the standalone
+// document. An empty allow list allows every package that is not denied.
+type PackageFilter struct {
+	allow map[string]bool
+	deny  map[string]bool
+}
+
+func NewPackageFilter(allow, deny []string) *PackageFilter {
+	f := &PackageFilter{allow: make(map[string]bool), deny: make(map[string]bool)}
+	for _, name := range allow {
+		f.allow[name] = true
+	}
+	for _, name := range deny {
+		f.deny[name] = true
+	}
+	return f
+}
+
+func (f *PackageFilter) Allowed(name string) bool {
+	if f.deny[name] {
+		return false
+	}
+	return len(f.allow) == 0 || f.allow[name]
+}
+
+func (f *PackageFilter) Filter(packages []Package) []Package {
+	var allowed []Package
+	for _, pkg := range packages {
+		if f.Allowed(pkg.Name) {
+			allowed = append(allowed, pkg)
+		}
+	}
+	return allowed
+}
@@ -0,0 +1,91 @@
+package src
+
+import (
+	"bufio"
+	"bytes"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// PayloadType is the kind of file inside a gzipped arXiv submission.
+type PayloadType int
+
+const (
+	PayloadTar PayloadType = iota
+	PayloadTeX
+	PayloadPDF
+	PayloadOther
+)
+
+// bareTeXName is the file name given to submissions that are a single .tex file.
+const bareTeXName = "main.tex"
+
+func (t PayloadType) String() string {
+	switch t {
+	case PayloadTar:
+		return "tar"
+	case PayloadTeX:
+		return "tex"
+	case PayloadPDF:
+		return "pdf"
+	default:
+		return "other"
+	}
+}
+
+// peekPayload returns the first bytes of r without consuming them. A tar header
+// is 512 bytes, which is enough for every type DetectPayload knows.
+func peekPayload(r *bufio.Reader) []byte {
+	header, _ := r.Peek(512)
+	return header
+}
+
+// DetectPayload guesses the payload type from its first bytes.
+func DetectPayload(header []byte) PayloadType {
+	// POSIX and GNU tar both have "ustar" at offset 257
+	if len(header) >= 262 && bytes.Equal(header[257:262], []byte("ustar")) {
+		return PayloadTar
+	}
+	if bytes.HasPrefix(header, []byte("%PDF")) {
+		return PayloadPDF
+	}
+	// PostScript-only submissions are text too, but not TeX
+	if bytes.HasPrefix(header, []byte("%!PS")) {
+		return PayloadOther
+	}
+	if len(header) == 0 || bytes.IndexByte(header, 0) != -1 {
+		return PayloadOther
+	}
+	// a multi-byte rune may be cut at the end of the header
+	text := header
+	for i := 0; i < utf8.UTFMax && len(text) > 0 && !utf8.Valid(text); i++ {
+		text = text[:len(text)-1]
+	}
+	if utf8.Valid(text) || isLatin1Text(header) {
+		return PayloadTeX
+	}
+	return PayloadOther
+}
+
+// isLatin1Text accepts old submissions written in 8-bit encodings.
+func isLatin1Text(header []byte) bool {
+	for _, b := range header {
+		if b < 0x20 && b != '\n' && b != '\r' && b != '\t' && b != '\f' {
+			return false
+		}
+	}
+	return true
+}
+
+// PayloadCounter counts submissions by payload type across concurrent workers.
+type PayloadCounter struct {
+	counts [PayloadOther + 1]atomic.Int64
+}
+
+func (c *PayloadCounter) Add(t PayloadType) {
+	c.counts[t].Add(1)
+}
+
+func (c *PayloadCounter) Count(t PayloadType) int64 {
+	return c.counts[t].Load()
+}
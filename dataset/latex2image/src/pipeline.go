@@ -0,0 +1,590 @@
+package src
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"latex2image/src/assemble"
+	"latex2image/src/manifest"
+	"latex2image/src/state"
+)
+
+// The pipeline runs in stages connected by bounded channels:
+//
+//	extract (tar -> papers) -> parse (paper -> tables)
+//	-> compile (table -> pdf) -> render (pdf -> png) -> sink (metadata.jsonl)
+//
+// Every stage has its own number of workers. A slow stage blocks the stages in
+// front of it once its input queue is full, so memory and disk usage stay bounded.
+
+type tableJob struct {
+	paperID   string
+	split     Split
+	filePath  string
+	index     int
+	baseName  string
+	table     string
+	env       string
+	float     string
+	fullLatex string
+	// .sty and .cls files of the paper the table is compiled with
+	packageFiles []*localPackage
+	// fixes of fullLatex that made it compile
+	repairs []string
+	// why the table was rejected before compiling
+	err error
+}
+
+// key identifies a table in the state store, e.g. 2001.00001/main.tex#3.
+func (job tableJob) key() string {
+	return job.filePath + "#" + strconv.Itoa(job.index)
+}
+
+type compiledTable struct {
+	job     tableJob
+	split   Split
+	tmpDir  string
+	pdfFile string
+}
+
+type renderedTable struct {
+	job         tableJob
+	split       Split
+	pngFileName string
+}
+
+type Pipeline struct {
+	cfg      *Config
+	quota    *QuotaTracker
+	assigner *SplitAssigner
+	packages *PackageFilter
+	payloads PayloadCounter
+	manifest *manifest.Manifest
+	store    *state.Store
+	progress *completion
+}
+
+// NewPipeline restores the split counts from the state store, so a rerun only
+// fills what is missing.
+func NewPipeline(cfg *Config, splits []Split, store *state.Store) (*Pipeline, error) {
+	for _, split := range splits {
+		if err := os.MkdirAll(split.Dir, 0755); err != nil {
+			fmt.Printf("failed to create directory: %v\n", err)
+		}
+	}
+
+	counts, err := store.SplitCounts()
+	if err != nil {
+		return nil, err
+	}
+	quota := NewQuotaTracker(splits)
+	quota.Restore(counts)
+
+	p := &Pipeline{
+		cfg:      cfg,
+		quota:    quota,
+		assigner: NewSplitAssigner(splits, cfg.SplitSeed),
+		packages: NewPackageFilter(cfg.AllowPackages, cfg.DenyPackages),
+		store:    store,
+	}
+	p.progress = newCompletion(p.markDone)
+	return p, nil
+}
+
+const (
+	tarKeyPrefix   = "tar/"
+	paperKeyPrefix = "paper/"
+	tableKeyPrefix = "table/"
+)
+
+// markDone is called once every table of a paper, or every paper of a tar, is
+// finished.
+func (p *Pipeline) markDone(key string) {
+	switch {
+	case strings.HasPrefix(key, tarKeyPrefix):
+		p.record(state.KindTar, strings.TrimPrefix(key, tarKeyPrefix), state.Record{Status: state.StatusDone})
+	case strings.HasPrefix(key, paperKeyPrefix):
+		p.record(state.KindPaper, strings.TrimPrefix(key, paperKeyPrefix), state.Record{Status: state.StatusDone})
+	}
+}
+
+func (p *Pipeline) record(kind state.Kind, key string, record state.Record) {
+	if err := p.store.Put(kind, key, record); err != nil {
+		fmt.Printf("Error saving state of %s: %v\n", key, err)
+	}
+}
+
+func (p *Pipeline) finished(kind state.Kind, key string) bool {
+	record, found, err := p.store.Get(kind, key)
+	if err != nil {
+		fmt.Printf("Error reading state of %s: %v\n", key, err)
+		return false
+	}
+	return found && record.Finished()
+}
+
+// tableFailed records a failed table. Failures caused by cancellation are not
+// recorded, so the table is tried again by the next run.
+func (p *Pipeline) tableFailed(ctx context.Context, job tableJob, err error) {
+	if ctx.Err() != nil {
+		return
+	}
+	class, name := classifyFailure(err)
+	p.record(state.KindTable, job.key(), state.Record{Status: state.StatusFailed, Reason: failureReason(err), Class: class, Detail: name})
+	p.progress.seal(tableKeyPrefix + job.key())
+}
+
+// failureReason keeps the first line of an error, which is short enough for the
+// state store.
+func failureReason(err error) string {
+	if err == nil {
+		return ""
+	}
+	reason, _, _ := strings.Cut(err.Error(), "\n")
+	if len(reason) > 200 {
+		reason = reason[:200]
+	}
+	return reason
+}
+
+func (p *Pipeline) Count(name string) int {
+	return p.quota.Count(name)
+}
+
+// UseManifest enables checking every tar against the manifest before it is read
+// when VerifyTars is set.
+func (p *Pipeline) UseManifest(m *manifest.Manifest) {
+	p.manifest = m
+}
+
+// PayloadCount returns how many submissions of a payload type were read.
+func (p *Pipeline) PayloadCount(t PayloadType) int64 {
+	return p.payloads.Count(t)
+}
+
+// RunTars generates the dataset from arXiv_src_*.tar files.
+func (p *Pipeline) RunTars(ctx context.Context, tarPaths []string) error {
+	return p.run(ctx, tarPaths, p.readArXivTar)
+}
+
+// RunPapers generates the dataset from already extracted paper folders.
+func (p *Pipeline) RunPapers(ctx context.Context, paperFolders []string) error {
+	return p.run(ctx, paperFolders, func(ctx context.Context, paperFolder string, emit func(Paper) bool) {
+		p.emitPaper(diskPaper(paperFolder, ""), emit)
+	})
+}
+
+func diskPaper(paperFolder string, tarName string) Paper {
+	return Paper{ID: filepath.Base(paperFolder), Tar: tarName, FS: newConfinedFS(paperFolder)}
+}
+
+// emitPaper skips finished papers and registers the others under their tar.
+func (p *Pipeline) emitPaper(paper Paper, emit func(Paper) bool) bool {
+	if p.finished(state.KindPaper, paper.ID) {
+		return true
+	}
+	parent := ""
+	if paper.Tar != "" {
+		parent = tarKeyPrefix + paper.Tar
+	}
+	p.progress.add(paperKeyPrefix+paper.ID, parent)
+	return emit(paper)
+}
+
+// run returns the error of the parent context. Stopping because all splits are
+// full is not an error.
+func (p *Pipeline) run(parent context.Context, sources []string, extract func(context.Context, string, func(Paper) bool)) error {
+	if p.quota.Full() {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	sourceCh := make(chan string)
+	go func() {
+		defer close(sourceCh)
+		for _, source := range sources {
+			if !send(ctx, sourceCh, source) {
+				return
+			}
+		}
+	}()
+
+	queueSize := p.cfg.QueueSize
+	papers := stage(ctx, p.cfg.ExtractWorkers, queueSize, sourceCh, extract)
+	tables := stage(ctx, p.cfg.ParseWorkers, queueSize, papers, p.parsePaper)
+	compiled := stage(ctx, p.cfg.CompileWorkers, queueSize, tables, p.compileTable)
+	rendered := stage(ctx, p.cfg.RenderWorkers, queueSize, compiled, p.renderTable)
+
+	for table := range rendered {
+		newMetadata := Metadata{
+			FileName:    table.pngFileName,
+			GroundTruth: replace_norm(table.job.table),
+			Environment: table.job.env,
+			Float:       table.job.float,
+			Repairs:     table.job.repairs,
+		}
+		appendMetaInfo(newMetadata, table.split.Dir)
+		p.record(state.KindTable, table.job.key(), state.Record{
+			Status:   state.StatusRendered,
+			Split:    table.split.Name,
+			FileName: table.pngFileName,
+		})
+		p.progress.seal(tableKeyPrefix + table.job.key())
+		p.quota.Commit(table.split)
+		if p.quota.Full() {
+			cancel()
+		}
+	}
+	// the render workers are done, tables still queued for them when the
+	// pipeline stopped leave no compile directories behind
+	for table := range compiled {
+		os.RemoveAll(table.tmpDir)
+		p.quota.Release(table.split)
+	}
+	return parent.Err()
+}
+
+// stage starts workers that read from in and emit into the returned channel,
+// which is closed once all workers are done.
+func stage[T, U any](ctx context.Context, workers int, queueSize int, in <-chan T, fn func(context.Context, T, func(U) bool)) <-chan U {
+	if workers < 1 {
+		workers = 1
+	}
+	out := make(chan U, queueSize)
+	emit := func(v U) bool {
+		return send(ctx, out, v)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case item, ok := <-in:
+					if !ok {
+						return
+					}
+					fn(ctx, item, emit)
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+func send[T any](ctx context.Context, out chan<- T, v T) bool {
+	select {
+	case out <- v:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (p *Pipeline) readArXivTar(ctx context.Context, yearIndexTarPath string, emit func(Paper) bool) {
+	tarName := filepath.Base(yearIndexTarPath)
+	if p.finished(state.KindTar, tarName) {
+		fmt.Printf("%s is done\n", tarName)
+		return
+	}
+	if p.manifest != nil && p.cfg.VerifyTars {
+		if f, ok := p.manifest.Lookup(filepath.Base(yearIndexTarPath)); ok {
+			status, err := manifest.VerifyFile(f, yearIndexTarPath, true)
+			if err != nil || status != manifest.StatusOK {
+				fmt.Printf("skip %s: %v %v\n", yearIndexTarPath, status, err)
+				return
+			}
+		} else {
+			fmt.Printf("%s is not in the manifest, not verified\n", yearIndexTarPath)
+		}
+	}
+
+	p.progress.add(tarKeyPrefix+tarName, "")
+
+	if p.cfg.Stream {
+		err := StreamArXivTar(yearIndexTarPath, p.cfg.PaperLimits(), func(paperID string, payloadType PayloadType, paperFS *MemFS) bool {
+			p.payloads.Add(payloadType)
+			if paperFS == nil {
+				fmt.Printf("skip %s, no TeX source (%v)\n", paperID, payloadType)
+				return true
+			}
+			return p.emitPaper(Paper{ID: paperID, Tar: tarName, FS: paperFS}, emit)
+		})
+		if err != nil {
+			fmt.Printf("read tar error %s: %v\n", yearIndexTarPath, err)
+			return
+		}
+		if ctx.Err() == nil {
+			p.progress.seal(tarKeyPrefix + tarName)
+		}
+		return
+	}
+
+	// yearIndex = 2003_026
+	yearIndex := GetArXivYearIndex(filepath.Base(yearIndexTarPath))
+	if yearIndex == "" {
+		fmt.Println("Error extracting year from filename:", yearIndexTarPath)
+		return
+	}
+
+	yearIndexFolder := filepath.Join(p.cfg.WorkDir, yearIndex)
+
+	if !FolderExists(yearIndexFolder) {
+		fmt.Println("file folder not exists and need to decompression")
+		// the arXiv tars themselves are trusted, the limits apply to every paper
+		err := ExtractTar(yearIndexTarPath, yearIndexFolder, ExtractLimits{})
+		if err != nil {
+			fmt.Printf("decompression error: %v\n", err)
+		} else {
+			fmt.Println("decompression success")
+			p.record(state.KindTar, tarName, state.Record{Status: state.StatusExtracted})
+		}
+	} else {
+		fmt.Printf("folder %s is exists, do not need to be created\n", yearIndex)
+		if !p.cfg.Regenerate {
+			return
+		}
+	}
+
+	// read paper in yearIndexFolder
+	if p.readPaperGz(ctx, yearIndexFolder, tarName, emit) {
+		p.progress.seal(tarKeyPrefix + tarName)
+	}
+}
+
+// readPaperGz extracts and emits the papers of an extracted tar. It returns
+// false when it was cancelled before every paper was emitted.
+func (p *Pipeline) readPaperGz(ctx context.Context, basePath string, tarName string, emit func(Paper) bool) bool {
+	paperGzs, err := os.ReadDir(basePath)
+	if err != nil {
+		fmt.Println("Error reading directory:", err)
+	}
+	for _, paperGz := range paperGzs {
+		if ctx.Err() != nil {
+			return false
+		}
+		paperGzPath := filepath.Join(basePath, paperGz.Name())
+		paperFolder := filepath.Join(basePath, strings.TrimSuffix(paperGz.Name(), ".gz"))
+
+		if strings.HasSuffix(paperGz.Name(), ".pdf") {
+			p.payloads.Add(PayloadPDF)
+			continue
+		}
+		if strings.HasSuffix(paperGz.Name(), ".gz") {
+			if !FolderExists(paperFolder) {
+				fmt.Println("file folder not exists and need to be created")
+				payloadType, err := ExtractPaperGz(paperGzPath, paperFolder, p.cfg.PaperLimits())
+				p.payloads.Add(payloadType)
+				if IsLimitError(err) {
+					fmt.Printf("skip %s: %v\n", paperGz.Name(), err)
+					os.RemoveAll(paperFolder)
+					continue
+				} else if err != nil {
+					fmt.Printf("unzip error: %v\n", err)
+				} else if payloadType == PayloadPDF || payloadType == PayloadOther {
+					fmt.Printf("skip %s, no TeX source (%v)\n", paperGz.Name(), payloadType)
+					continue
+				} else {
+					fmt.Println("unzip success")
+					p.record(state.KindPaper, filepath.Base(paperFolder), state.Record{Status: state.StatusExtracted})
+				}
+			} else {
+				// extracted by an earlier run, the folder is an entry of its
+				// own and emitted there
+				continue
+			}
+		}
+
+		if !p.emitPaper(diskPaper(paperFolder, tarName), emit) {
+			return false
+		}
+	}
+	return ctx.Err() == nil
+}
+
+func (p *Pipeline) parsePaper(ctx context.Context, paper Paper, emit func(tableJob) bool) {
+	paperKey := paperKeyPrefix + paper.ID
+
+	// all tables of a paper go to the split of the paper, a paper of a full
+	// split stays open for a rerun with larger counts
+	split := p.assigner.Assign(paper.ID)
+	if p.quota.SplitFull(split) {
+		return
+	}
+
+	// find all .tex files from paper folder
+	paperTexFiles, err := FindTexFiles(paper.FS)
+	if err != nil {
+		fmt.Println("Error finding .tex files:", err)
+		p.record(state.KindPaper, paper.ID, state.Record{Status: state.StatusFailed, Reason: failureReason(err)})
+		p.progress.seal(paperKey)
+		return
+	}
+
+	docs, err := AssembleDocuments(paper, paperTexFiles, assemble.Options{SearchPath: p.cfg.TexInputs})
+	if err != nil {
+		fmt.Printf("Warning assembling %s:\n%v\n", paper.ID, err)
+	}
+	// a paper that loads files from outside of its folder is rejected as a
+	// whole
+	if errors.Is(err, assemble.ErrOutsideRoot) {
+		reason := ""
+		for _, line := range strings.Split(err.Error(), "\n") {
+			if strings.Contains(line, assemble.ErrOutsideRoot.Error()) {
+				reason = failureReason(errors.New(line))
+				break
+			}
+		}
+		p.record(state.KindPaper, paper.ID, state.Record{Status: state.StatusFailed, Reason: reason, Class: FailPathViolation})
+		p.progress.seal(paperKey)
+		return
+	}
+
+	// get predefined line from main tex file, with the definitions of the
+	// packages the paper ships
+	docHead := ""
+	var packageFiles []*localPackage
+	for _, doc := range docs {
+		tmpDocHead, err := ExtractPreamble(doc.Source)
+		if err != nil {
+			continue
+		}
+		packageFiles = loadLocalPackages(paper.FS, path.Dir(doc.Root), doc.Source)
+		docHead = localPreamble(packageFiles, tmpDocHead)
+		break
+	}
+
+	tables := 0
+	for _, doc := range docs {
+		for _, job := range ProcessTexFile(docHead, paper, doc, p.packages) {
+			// rendered and failed tables of an earlier run are not redone
+			if p.finished(state.KindTable, job.key()) {
+				continue
+			}
+			if job.err != nil {
+				p.tableFailed(ctx, job, job.err)
+				continue
+			}
+			job.split = split
+			job.packageFiles = packageFiles
+			p.progress.add(tableKeyPrefix+job.key(), paperKey)
+			if !emit(job) {
+				return
+			}
+			tables++
+		}
+	}
+	p.record(state.KindPaper, paper.ID, state.Record{Status: state.StatusParsed, Tables: tables})
+	p.progress.seal(paperKey)
+}
+
+// compileTable compiles a table in its own temporary directory. Only the png
+// (and in debug mode the .tex/.pdf/.log/.aux) end up in the split directory.
+func (p *Pipeline) compileTable(ctx context.Context, job tableJob, emit func(compiledTable) bool) {
+	split := job.split
+	if !p.quota.Acquire(ctx, split) {
+		// the split is full or the pipeline is cancelled
+		return
+	}
+
+	tmpDir, err := os.MkdirTemp("", "latex2image-")
+	if err != nil {
+		fmt.Printf("Error creating temp dir for %s: %v\n", job.filePath, err)
+		p.quota.Release(split)
+		return
+	}
+
+	tmpName := fmt.Sprintf("%s_table_%d.", job.baseName, job.index)
+	tableTexFile := filepath.Join(tmpDir, fmt.Sprintf("%stex", tmpName))
+	tablePdfFile := filepath.Join(tmpDir, fmt.Sprintf("%spdf", tmpName))
+	if err := writeLocalPackages(tmpDir, job.packageFiles); err != nil {
+		fmt.Printf("Error copying the packages of %s: %v\n", job.filePath, err)
+	}
+
+	compiled, repairs, err := compileWithRepairs(ctx, tableTexFile, tablePdfFile, job.fullLatex,
+		p.cfg.CompileTimeout, p.cfg.CompileAttempts, p.packages)
+	if err == nil && !FolderExists(tablePdfFile) {
+		err = &Failure{Class: FailNoPDF, Err: fmt.Errorf("no pdf was written")}
+	}
+	if err == nil && len(repairs) > 0 {
+		// the ground truth is the table the image shows
+		table, ok := repairedTable(compiled)
+		if !ok {
+			err = &Failure{Class: FailOther, Err: fmt.Errorf("repaired table not found")}
+		}
+		job.table, job.repairs = table, repairs
+	}
+	if err != nil {
+		// pdflatex writes a pdf despite errors, it is not a sample
+		fmt.Printf("Error compiling LaTeX for %s: %v\n", job.filePath, err)
+		os.RemoveAll(tmpDir)
+		p.quota.Release(split)
+		p.tableFailed(ctx, job, err)
+		return
+	}
+	if len(repairs) > 0 {
+		fmt.Printf("Repaired table %d of %s: %s\n", job.index+1, job.filePath, strings.Join(repairs, ", "))
+	}
+	p.record(state.KindTable, job.key(), state.Record{Status: state.StatusCompiled, Split: split.Name})
+
+	if !emit(compiledTable{job: job, split: split, tmpDir: tmpDir, pdfFile: tablePdfFile}) {
+		os.RemoveAll(tmpDir)
+		p.quota.Release(split)
+	}
+}
+
+func (p *Pipeline) renderTable(ctx context.Context, table compiledTable, emit func(renderedTable) bool) {
+	pngFileName, err := convertPDFtoPNG(table.pdfFile, table.split.Dir, p.cfg.MinImageWidth, p.cfg.MinImageHeight)
+	if p.cfg.Debug {
+		keepDebugFiles(table.pdfFile, table.split.Dir)
+	}
+	os.RemoveAll(table.tmpDir)
+
+	if err != nil {
+		fmt.Printf("Error converting PDF to PNG for %s: %v\n", table.job.filePath, err)
+		p.quota.Release(table.split)
+		p.tableFailed(ctx, table.job, err)
+		return
+	}
+	fmt.Printf("Table %d from %s converted to %s\n", table.job.index+1, table.job.filePath, table.split.Dir)
+
+	if !emit(renderedTable{job: table.job, split: table.split, pngFileName: pngFileName}) {
+		// the pipeline stopped, an image without metadata is no sample
+		os.Remove(filepath.Join(table.split.Dir, pngFileName))
+		p.quota.Release(table.split)
+	}
+}
+
+// debugExts are the files of a table's compile that are kept for debugging. The
+// .sty and .cls files of the paper next to them are not copied.
+var debugExts = []string{".tex", ".log", ".aux", ".pdf"}
+
+// keepDebugFiles copies the files of the compile of pdfFile into the split
+// directory.
+func keepDebugFiles(pdfFile string, splitDir string) {
+	base := strings.TrimSuffix(pdfFile, ".pdf")
+	for _, ext := range debugExts {
+		content, err := os.ReadFile(base + ext)
+		if err != nil {
+			continue
+		}
+		os.WriteFile(filepath.Join(splitDir, filepath.Base(base)+ext), content, 0644)
+	}
+}
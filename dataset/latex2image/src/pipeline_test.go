@@ -0,0 +1,62 @@
+package src
+
+import (
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"latex2image/src/state"
+)
+
+func writeGz(t *testing.T, name, content string) {
+	t.Helper()
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gzw := gzip.NewWriter(f)
+	if _, err := gzw.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A rerun over a work dir whose papers were extracted by an earlier run emits
+// every paper once, from its folder.
+func TestReadPaperGzRerun(t *testing.T) {
+	store, err := state.Open(filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	base := t.TempDir()
+	for _, id := range []string{"2001.00001", "2001.00002"} {
+		writeGz(t, filepath.Join(base, id+".gz"), "\\documentclass{article}\n\\begin{document}\n"+id+"\n\\end{document}\n")
+	}
+
+	for run := 0; run < 2; run++ {
+		p, err := NewPipeline(&Config{}, nil, store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var emitted []string
+		done := p.readPaperGz(context.Background(), base, "arXiv_src_2001_001.tar", func(paper Paper) bool {
+			emitted = append(emitted, paper.ID)
+			return true
+		})
+		if !done {
+			t.Fatalf("run %d was not done", run)
+		}
+		slices.Sort(emitted)
+		if want := []string{"2001.00001", "2001.00002"}; !slices.Equal(emitted, want) {
+			t.Errorf("run %d emitted %v, want %v", run, emitted, want)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package src
+
+import (
+	"sort"
+	"strings"
+
+	"latex2image/src/latex"
+	"latex2image/src/macro"
+)
+
+// notConditionals look like conditionals but are macros with arguments that do
+// not end with \fi, from ifthen, etoolbox and babel. Every other \if... starts a
+// conditional, including the switches of classes and packages.
+var notConditionals = map[string]bool{
+	`\ifthenelse`: true, `\ifboolexpr`: true, `\ifboolexpe`: true, `\ifbool`: true, `\ifnotbool`: true,
+	`\iftoggle`: true, `\ifnottoggle`: true, `\ifdef`: true, `\ifndef`: true, `\ifundef`: true,
+	`\ifcsdef`: true, `\ifcsundef`: true, `\ifdefmacro`: true, `\ifcsmacro`: true, `\ifdefparam`: true,
+	`\ifcsparam`: true, `\ifdefprefix`: true, `\ifcsprefix`: true, `\ifdefprotected`: true,
+	`\ifcsprotected`: true, `\ifdefltxprotect`: true, `\ifcsltxprotect`: true, `\ifdefempty`: true,
+	`\ifcsempty`: true, `\ifdefvoid`: true, `\ifcsvoid`: true, `\ifdefequal`: true, `\ifcsequal`: true,
+	`\ifdefstring`: true, `\ifcsstring`: true, `\ifdefstrequal`: true, `\ifcsstrequal`: true,
+	`\ifdefcounter`: true, `\ifcscounter`: true, `\ifltxcounter`: true, `\ifdeflength`: true,
+	`\ifcslength`: true, `\ifdefdimen`: true, `\ifcsdimen`: true, `\ifstrequal`: true, `\ifstrempty`: true,
+	`\ifblank`: true, `\ifnumcomp`: true, `\ifnumequal`: true, `\ifnumgreater`: true, `\ifnumless`: true,
+	`\ifnumodd`: true, `\ifdimcomp`: true, `\ifdimequal`: true, `\ifdimgreater`: true, `\ifdimless`: true,
+	`\ifinlist`: true, `\ifinlistcs`: true, `\ifrmnum`: true, `\ifpatchable`: true, `\iflanguage`: true,
+	`\ifmtarg`: true,
+}
+
+// cleanSource is a source without comments and dead code. It remembers where
+// its parts were in the original source.
+type cleanSource struct {
+	text string
+	// offsets in text and in the original source where a token starts
+	offsets []int
+	origins []int
+}
+
+// origin returns the offset in the original source of offset in text.
+func (c *cleanSource) origin(offset int) int {
+	i := sort.SearchInts(c.offsets, offset+1) - 1
+	if i < 0 {
+		return offset
+	}
+	return c.origins[i] + offset - c.offsets[i]
+}
+
+// conditional is an \if... whose \fi has not been read yet. Only \iftrue,
+// \iffalse and the switches of \newif are evaluated, the others are kept and
+// only counted, so that their \else and \fi are not taken for those of an
+// evaluated conditional around them.
+type conditional struct {
+	evaluated bool
+	// the branch being read is kept
+	active bool
+}
+
+// preprocess removes what TeX does not read: comments, the comment
+// environment, the false branches of \iffalse, \iftrue and of the switches made
+// with \newif, whose values are followed through \footrue and \foofalse.
+// Verbatim environments and \verb stay as they are.
+func preprocess(src string) *cleanSource {
+	return preprocessTokens(latex.Tokenize(src))
+}
+
+// preprocessPackage preprocesses a .sty or .cls file, in which @ is a letter.
+func preprocessPackage(src string) *cleanSource {
+	return preprocessTokens(latex.TokenizePackage(src))
+}
+
+func preprocessTokens(tokens []latex.Token) *cleanSource {
+	switches := make(map[string]bool)
+	// \if... names defined as macros
+	macros := make(map[string]bool)
+	var stack []conditional
+	skipping := func() bool {
+		for _, c := range stack {
+			if c.evaluated && !c.active {
+				return true
+			}
+		}
+		return false
+	}
+	// the tokens before namesEnd are the names a definition defines, which do
+	// not start a conditional
+	namesEnd := 0
+
+	var out []latex.Token
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		switch tok.Kind {
+		case latex.Comment:
+			// the comment takes the line break and the spaces starting the
+			// next line with it
+			if i+1 < len(tokens) && tokens[i+1].Kind == latex.Space {
+				next := &tokens[i+1]
+				_, rest, _ := strings.Cut(next.Text, "\n")
+				trimmed := strings.TrimLeft(rest, " \t")
+				next.Pos.Offset += len(next.Text) - len(trimmed)
+				next.Text = trimmed
+			}
+			continue
+		case latex.Verbatim:
+			if strings.HasPrefix(tok.Text, `\begin{comment}`) {
+				continue
+			}
+		case latex.ControlWord:
+			if i < namesEnd {
+				break
+			}
+			name := tok.Text
+			value, isSwitch := switches[strings.TrimPrefix(name, `\if`)]
+			isSwitch = isSwitch && strings.HasPrefix(name, `\if`)
+			switch {
+			case name == `\iftrue` || name == `\iffalse` || isSwitch:
+				stack = append(stack, conditional{evaluated: true, active: name == `\iftrue` || isSwitch && value})
+				continue
+			case strings.HasPrefix(name, `\if`) && !notConditionals[name] && !macros[name]:
+				stack = append(stack, conditional{})
+			case name == `\else` && len(stack) > 0 && stack[len(stack)-1].evaluated:
+				stack[len(stack)-1].active = !stack[len(stack)-1].active
+				continue
+			case name == `\fi` && len(stack) > 0:
+				top := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				if top.evaluated {
+					continue
+				}
+			case name == `\newif` || macro.IsDefinition(name):
+				namesEnd = i + macro.DefinedNames(tokens[i:])
+				if !skipping() {
+					defineConditionals(name, tokens[i+1:namesEnd], switches, macros)
+				}
+			case !skipping():
+				for _, suffix := range []string{"true", "false"} {
+					if name, ok := strings.CutSuffix(strings.TrimPrefix(name, `\`), suffix); ok {
+						if _, defined := switches[name]; defined {
+							switches[name] = suffix == "true"
+						}
+					}
+				}
+			}
+		}
+		if !skipping() {
+			out = append(out, tok)
+		}
+	}
+	return joinClean(out)
+}
+
+// defineConditionals records the \if... names that the definition command
+// defines with names: \newif makes a switch that is false, \let\ifname\iftrue
+// one that is true and a macro named \if... is not a conditional.
+func defineConditionals(command string, names []latex.Token, switches map[string]bool, macros map[string]bool) {
+	var words []string
+	for _, tok := range names {
+		if tok.Kind == latex.ControlWord {
+			words = append(words, tok.Text)
+		}
+	}
+	if len(words) == 0 || !strings.HasPrefix(words[0], `\if`) || words[0] == `\if` {
+		return
+	}
+	name := words[0]
+	delete(switches, strings.TrimPrefix(name, `\if`))
+	delete(macros, name)
+	switch {
+	case command == `\newif`:
+		switches[strings.TrimPrefix(name, `\if`)] = false
+	case command == `\let` && len(words) == 2 && (words[1] == `\iftrue` || words[1] == `\iffalse`):
+		switches[strings.TrimPrefix(name, `\if`)] = words[1] == `\iftrue`
+	case command == `\let` && len(words) == 2 && strings.HasPrefix(words[1], `\if`) && !notConditionals[words[1]]:
+		// a copy of another conditional
+	default:
+		macros[name] = true
+	}
+}
+
+// joinClean concatenates tokens like latex.Join and records their origins.
+func joinClean(tokens []latex.Token) *cleanSource {
+	c := &cleanSource{}
+	var b strings.Builder
+	prev := latex.Space
+	for _, tok := range tokens {
+		if tok.Text == "" {
+			continue
+		}
+		if prev == latex.ControlWord && tok.Kind == latex.Text && isASCIILetter(tok.Text[0]) {
+			b.WriteByte(' ')
+		}
+		prev = tok.Kind
+		c.offsets = append(c.offsets, b.Len())
+		c.origins = append(c.origins, tok.Pos.Offset)
+		b.WriteString(tok.Text)
+	}
+	c.text = b.String()
+	return c
+}
+
+func isASCIILetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
@@ -0,0 +1,47 @@
+package src
+
+import "testing"
+
+func TestPreprocess(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"a % comment\n   b", "a b"},
+		{`a\begin{comment}b\end{comment}c`, "ac"},
+		{`a\iffalse b\else c\fi d`, "a c d"},
+		{`a\iftrue b\else c\fi d`, "a b d"},
+		// unknown conditionals are kept and nest inside evaluated ones
+		{`\iffalse \ifdraft X\else Y\fi Z\fi tail`, " tail"},
+		{`\iffalse \ifx\a\b X\else Y\fi \fi tail`, " tail"},
+		{`\iftrue \ifdraft X\else Y\fi\else Z\fi`, ` \ifdraft X\else Y\fi`},
+		{`\ifCLASSOPTIONcompsoc \iffalse A\fi B\else C\fi`, `\ifCLASSOPTIONcompsoc  B\else C\fi`},
+		// switches of \newif start false and follow \footrue and \foofalse
+		{`\newif\ifshow \ifshow \begin{table}A\end{table}\else \ifdraft B\else C\fi\fi D`, `\newif\ifshow  \ifdraft B\else C\fi D`},
+		{`\newif\ifshow \showtrue \ifshow \ifdraft A\fi\else B\fi`, `\newif\ifshow \showtrue  \ifdraft A\fi`},
+		{`\newif\ifshow \showtrue\showfalse \ifshow A\fi`, `\newif\ifshow \showtrue\showfalse `},
+		{`\iffalse \newif\ifhidden \fi\ifhidden A\fi`, `\ifhidden A\fi`},
+		{`\let\ifmine\iftrue \ifmine A\else B\fi`, `\let\ifmine\iftrue  A`},
+		// macros that look like conditionals
+		{`\ifthenelse{\boolean{x}}{a}{b}\iffalse z\fi`, `\ifthenelse{\boolean{x}}{a}{b}`},
+		{`\def\ifempty{x}\ifempty\iffalse a\fi b`, `\def\ifempty{x}\ifempty b`},
+		{`\newcommand{\ifwide}[1]{#1}\ifwide{a}\iffalse b\fi c`, `\newcommand{\ifwide}[1]{#1}\ifwide{a} c`},
+		{`\verb|\iffalse| a`, `\verb|\iffalse| a`},
+	}
+	for _, tt := range tests {
+		if got := preprocess(tt.src).text; got != tt.want {
+			t.Errorf("preprocess(%q) = %q, want %q", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestPreprocessOrigin(t *testing.T) {
+	src := "% comment\n\\iffalse x\\fi table"
+	c := preprocess(src)
+	if c.text != " table" {
+		t.Fatalf("text = %q", c.text)
+	}
+	if got, want := c.origin(3), len(src)-len("ble"); got != want {
+		t.Errorf("origin(2) = %d, want %d", got, want)
+	}
+}
@@ -0,0 +1,67 @@
+package src
+
+import "sync"
+
+// completion tracks open children of tars and papers. A key is done once it is
+// sealed (all children were added) and every child is done; then onDone is
+// called and its parent is updated.
+type completion struct {
+	mu     sync.Mutex
+	open   map[string]int
+	sealed map[string]bool
+	parent map[string]string
+	onDone func(key string)
+}
+
+func newCompletion(onDone func(key string)) *completion {
+	return &completion{
+		open:   make(map[string]int),
+		sealed: make(map[string]bool),
+		parent: make(map[string]string),
+		onDone: onDone,
+	}
+}
+
+// add registers key as a child of parent. An empty parent means a root.
+func (c *completion) add(key string, parent string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.open[key] = 0
+	c.parent[key] = parent
+	if parent != "" {
+		c.open[parent]++
+	}
+}
+
+// seal marks that all children of key were added.
+func (c *completion) seal(key string) {
+	var done []string
+	c.mu.Lock()
+	if _, exists := c.open[key]; exists {
+		c.sealed[key] = true
+		done = c.collect(key)
+	}
+	c.mu.Unlock()
+
+	for _, key := range done {
+		c.onDone(key)
+	}
+}
+
+// collect removes key and its finished ancestors and returns them in order.
+func (c *completion) collect(key string) []string {
+	var done []string
+	for key != "" && c.sealed[key] && c.open[key] == 0 {
+		parent := c.parent[key]
+		delete(c.open, key)
+		delete(c.sealed, key)
+		delete(c.parent, key)
+		done = append(done, key)
+		if parent == "" {
+			break
+		}
+		c.open[parent]--
+		key = parent
+	}
+	return done
+}
@@ -0,0 +1,105 @@
+package src
+
+import (
+	"context"
+	"sync"
+)
+
+// QuotaTracker hands out dataset slots to concurrent workers. A worker reserves
+// a slot in the split of its paper before compiling a table and commits or
+// releases it afterwards, so the number of samples per split never exceeds its
+// count.
+type QuotaTracker struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	splits   []Split
+	done     map[string]int
+	reserved map[string]int
+}
+
+func NewQuotaTracker(splits []Split) *QuotaTracker {
+	q := &QuotaTracker{
+		splits:   splits,
+		done:     make(map[string]int),
+		reserved: make(map[string]int),
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// Restore sets the finished samples of earlier runs.
+func (q *QuotaTracker) Restore(counts map[string]int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for name, count := range counts {
+		q.done[name] = count
+	}
+}
+
+// Acquire reserves a slot in split. While the only free slots of the split are
+// reserved by other workers it waits, because those reservations may still be
+// released. It returns false when the split is full or ctx is cancelled.
+func (q *QuotaTracker) Acquire(ctx context.Context, split Split) bool {
+	stop := context.AfterFunc(ctx, func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		q.cond.Broadcast()
+	})
+	defer stop()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for {
+		if ctx.Err() != nil || q.done[split.Name] >= split.Count {
+			return false
+		}
+		if q.done[split.Name]+q.reserved[split.Name] < split.Count {
+			q.reserved[split.Name]++
+			return true
+		}
+		q.cond.Wait()
+	}
+}
+
+// Commit turns a reservation into a finished sample.
+func (q *QuotaTracker) Commit(split Split) {
+	q.mu.Lock()
+	q.reserved[split.Name]--
+	q.done[split.Name]++
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+// Release gives a reservation back after a failed table.
+func (q *QuotaTracker) Release(split Split) {
+	q.mu.Lock()
+	q.reserved[split.Name]--
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+func (q *QuotaTracker) Count(name string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.done[name]
+}
+
+// SplitFull reports whether split has reached its count.
+func (q *QuotaTracker) SplitFull(split Split) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.done[split.Name] >= split.Count
+}
+
+// Full reports whether every split has reached its count.
+func (q *QuotaTracker) Full() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, split := range q.splits {
+		if q.done[split.Name] < split.Count {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,345 @@
+package src
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"latex2image/src/latex"
+)
+
+// logError is an error pdflatex reported in its log.
+type logError struct {
+	// one of the Fail classes
+	class string
+	// undefined command or missing file
+	name string
+	// line of the document and the source read up to the error, as pdflatex
+	// prints them after l.
+	line    int
+	context string
+}
+
+var (
+	missingFileRe = regexp.MustCompile("^! LaTeX Error: File `([^']+)' not found")
+	contextLineRe = regexp.MustCompile(`^l\.(\d+) ?(.*)$`)
+	controlWordRe = regexp.MustCompile(`\\[A-Za-z@]+`)
+)
+
+// parseLog returns the errors of a pdflatex log in order.
+func parseLog(log string) []logError {
+	var errs []logError
+	lines := strings.Split(log, "\n")
+	for i, line := range lines {
+		var e logError
+		switch {
+		case strings.HasPrefix(line, "! Undefined control sequence"):
+			e.class = FailUndefined
+		case strings.HasPrefix(line, "! LaTeX Error: File `"):
+			m := missingFileRe.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+			e.class, e.name = FailMissingFile, m[1]
+		case strings.HasPrefix(line, "! Missing $ inserted"):
+			e.class = FailMissingDollar
+		case strings.HasPrefix(line, "! Misplaced alignment tab character"):
+			e.class = FailMisplacedTab
+		case strings.HasPrefix(line, "Runaway argument"),
+			strings.HasPrefix(line, "! Paragraph ended before"),
+			strings.HasPrefix(line, "! File ended while scanning"):
+			e.class = FailRunaway
+		default:
+			continue
+		}
+
+		// the context follows the message: <argument> lines for errors in
+		// macros, then l.<line> with the source read so far
+		first := ""
+		for _, next := range lines[i+1 : min(i+12, len(lines))] {
+			if strings.HasPrefix(next, "!") {
+				break
+			}
+			if first == "" && strings.TrimSpace(next) != "" && !strings.HasPrefix(next, "Runaway") {
+				first = next
+			}
+			if m := contextLineRe.FindStringSubmatch(next); m != nil {
+				e.line, _ = strconv.Atoi(m[1])
+				e.context = strings.TrimPrefix(m[2], "...")
+				break
+			}
+		}
+		if e.class == FailUndefined {
+			words := controlWordRe.FindAllString(first, -1)
+			if len(words) == 0 {
+				continue
+			}
+			e.name = words[len(words)-1]
+		}
+		if len(errs) > 0 && errs[len(errs)-1] == e {
+			// a runaway argument is reported twice
+			continue
+		}
+		errs = append(errs, e)
+	}
+	return errs
+}
+
+// repairDocument applies a repair for every error it knows how to fix to the
+// standalone document doc. It returns the repaired document and the repairs,
+// none when no error could be fixed.
+func repairDocument(doc string, errs []logError, filter *PackageFilter) (string, []string) {
+	var repairs []string
+	done := make(map[string]bool)
+	// the errors at a line of the log come first, the other repairs add and
+	// remove lines
+	for _, atLine := range []bool{true, false} {
+		for _, e := range errs {
+			if lineRepairs[e.class] != atLine {
+				continue
+			}
+			repaired, repair := repairError(doc, e, filter)
+			if repair == "" || done[repair] {
+				continue
+			}
+			done[repair] = true
+			doc = repaired
+			repairs = append(repairs, repair)
+		}
+	}
+	return doc, repairs
+}
+
+// lineRepairs edit the line of the document an error happened at.
+var lineRepairs = map[string]bool{
+	FailMissingDollar: true,
+	FailMisplacedTab:  true,
+}
+
+func repairError(doc string, e logError, filter *PackageFilter) (string, string) {
+	preamble, body, ok := strings.Cut(doc, `\begin{document}`)
+	if !ok {
+		return doc, ""
+	}
+	switch e.class {
+	case FailUndefined:
+		loaded := make(map[string]bool)
+		for _, pkg := range collectPackages(preamble) {
+			loaded[pkg.Name] = true
+		}
+		for _, name := range commandPackages[e.name] {
+			if !loaded[name] && filter.Allowed(name) {
+				return preamble + Package{Name: name}.String() + "\n" + `\begin{document}` + body, "add package " + name
+			}
+		}
+		if strings.Contains(e.name, "@") {
+			// internal command of a package, stubbing it would not help
+			return doc, ""
+		}
+		// the command is dropped from the table, its arguments stay text, so
+		// the table that is stored says what the image shows
+		if stripped, ok := stripCommand(body, e.name); ok {
+			return preamble + `\begin{document}` + stripped, "strip " + e.name
+		}
+		// used by a definition of the preamble, an empty command keeps the
+		// table compiling
+		stub := `\providecommand{` + e.name + `}{}`
+		if strings.Contains(preamble, stub) {
+			return doc, ""
+		}
+		return preamble + stub + "\n" + `\begin{document}` + body, "stub " + e.name
+
+	case FailMissingFile:
+		var kept []string
+		removed := false
+		for _, line := range strings.Split(preamble, "\n") {
+			if pkg := collectPackages(line); len(pkg) == 1 && pkg[0].Name+".sty" == e.name && strings.HasPrefix(line, `\usepackage`) {
+				removed = true
+				continue
+			}
+			kept = append(kept, line)
+		}
+		if !removed {
+			return doc, ""
+		}
+		return strings.Join(kept, "\n") + `\begin{document}` + body, "drop package " + strings.TrimSuffix(e.name, ".sty")
+
+	case FailMissingDollar:
+		return editErrorLine(doc, e, "wrap math", wrapCellMath)
+
+	case FailMisplacedTab:
+		return editErrorLine(doc, e, "escape &", func(line string, pos int) (string, bool) {
+			if pos == 0 || line[pos-1] != '&' {
+				return line, false
+			}
+			return line[:pos-1] + `\&` + line[pos:], true
+		})
+
+	case FailRunaway:
+		// a blank line ends every argument that is not \long
+		joined := blankLinesRe.ReplaceAllString(body, "\n")
+		if joined == body {
+			return doc, ""
+		}
+		return preamble + `\begin{document}` + joined, "join paragraphs"
+	}
+	return doc, ""
+}
+
+var blankLinesRe = regexp.MustCompile(`\n[ \t]*(\n[ \t]*)+`)
+
+// stripCommand removes every use of the control word name from src, and the
+// space TeX would skip after it.
+func stripCommand(src, name string) (string, bool) {
+	tokens := latex.Tokenize(src)
+	var kept []latex.Token
+	stripped := false
+	for i := 0; i < len(tokens); i++ {
+		if tokens[i].Kind != latex.ControlWord || tokens[i].Text != name {
+			kept = append(kept, tokens[i])
+			continue
+		}
+		stripped = true
+		if i+1 < len(tokens) && tokens[i+1].Kind == latex.Space && strings.Count(tokens[i+1].Text, "\n") < 2 {
+			i++
+		}
+	}
+	if !stripped {
+		return src, false
+	}
+	var b strings.Builder
+	for _, tok := range kept {
+		b.WriteString(tok.Text)
+	}
+	return b.String(), true
+}
+
+// repairedTable returns the tabular of a repaired standalone document, which
+// replaces the table of the paper when repairs changed it.
+func repairedTable(doc string) (string, bool) {
+	_, body, ok := strings.Cut(doc, `\begin{document}`)
+	if !ok {
+		return "", false
+	}
+	tables, _ := extractTables(body)
+	if len(tables) != 1 {
+		return "", false
+	}
+	return tables[0].source, true
+}
+
+// editErrorLine applies edit to the line of doc where e happened, at the
+// position pdflatex read up to.
+func editErrorLine(doc string, e logError, repair string, edit func(line string, pos int) (string, bool)) (string, string) {
+	lines := strings.Split(doc, "\n")
+	if e.line < 1 || e.line > len(lines) {
+		return doc, ""
+	}
+	line := lines[e.line-1]
+	pos := strings.Index(line, e.context)
+	if e.context == "" || pos < 0 {
+		return doc, ""
+	}
+	edited, ok := edit(line, pos+len(e.context))
+	if !ok {
+		return doc, ""
+	}
+	lines[e.line-1] = edited
+	return strings.Join(lines, "\n"), fmt.Sprintf("%s on line %d", repair, e.line)
+}
+
+// wrapCellMath puts the cell around pos in math mode, math commands like ^ and
+// \alpha used in text need it.
+func wrapCellMath(line string, pos int) (string, bool) {
+	start := strings.LastIndex(line[:pos], "&") + 1
+	if i := strings.LastIndex(line[:pos], `\\`); i >= 0 {
+		start = max(start, i+2)
+	}
+	end := len(line)
+	if i := strings.Index(line[pos:], "&"); i >= 0 {
+		end = pos + i
+	}
+	if i := strings.Index(line[pos:], `\\`); i >= 0 && pos+i < end {
+		end = pos + i
+	}
+	cell := strings.TrimSpace(line[start:end])
+	if cell == "" || strings.Contains(cell, "$") {
+		return line, false
+	}
+	i := start + strings.Index(line[start:end], cell)
+	return line[:i] + "$" + cell + "$" + line[i+len(cell):], true
+}
+
+// compileWithRepairs compiles the standalone document doc into pdfFile. When
+// pdflatex fails, the errors of its log are repaired and the document is
+// compiled again, up to attempts times in total. It returns the document that
+// was compiled last, the repairs that were applied and a Failure classified
+// from the last log. The pdf of a failed compile must not be used, pdflatex
+// writes one despite errors.
+func compileWithRepairs(ctx context.Context, texFile, pdfFile, doc string, timeout time.Duration, attempts int, filter *PackageFilter) (string, []string, error) {
+	logFile := strings.TrimSuffix(pdfFile, ".pdf") + ".log"
+	var repairs []string
+	for attempt := 1; ; attempt++ {
+		if err := os.WriteFile(texFile, []byte(doc), 0644); err != nil {
+			return doc, repairs, err
+		}
+		err := compileLaTeX(ctx, texFile, pdfFile, timeout)
+		if err == nil {
+			return doc, repairs, nil
+		}
+		var f *Failure
+		if errors.As(err, &f) {
+			// timed out or read outside of its directory, there is nothing
+			// to repair
+			return doc, repairs, err
+		}
+
+		log, readErr := readLog(logFile)
+		if readErr != nil {
+			return doc, repairs, &Failure{Class: FailLaTeX, Err: err}
+		}
+		errs := parseLog(log)
+		if len(errs) == 0 {
+			return doc, repairs, &Failure{Class: FailLaTeX, Err: err}
+		}
+		failure := &Failure{Class: errs[0].class, Name: errs[0].name, Err: err}
+		if attempt >= attempts || ctx.Err() != nil {
+			return doc, repairs, failure
+		}
+		repaired, applied := repairDocument(doc, errs, filter)
+		if len(applied) == 0 {
+			return doc, repairs, failure
+		}
+		doc = repaired
+		repairs = append(repairs, applied...)
+		os.Remove(pdfFile)
+	}
+}
+
+// readLog reads a pdflatex log. Its lines are wrapped at 79 characters, which
+// are joined again where a context line was cut.
+func readLog(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var b strings.Builder
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		b.WriteString(line)
+		if len(line) != 79 {
+			b.WriteByte('\n')
+		}
+	}
+	return b.String(), scanner.Err()
+}
@@ -0,0 +1,179 @@
+package src
+
+import (
+	"bufio"
+	"bytes"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+
+	"latex2image/src/assemble"
+	"latex2image/src/latex"
+)
+
+// readmeFile is where arXiv submissions name their top-level file and the
+// files arXiv should ignore.
+const readmeFile = "00README.XXX"
+
+// root names that usually hold the main document, and names of templates and
+// drafts that usually do not
+var (
+	rootNames     = []string{"main", "ms", "paper", "article", "manuscript", "root"}
+	unlikelyNames = []string{"template", "sample", "example", "test", "draft", "old", "backup", "copy"}
+)
+
+// rootCandidate is a .tex file that may be the main document of a paper.
+type rootCandidate struct {
+	file  string
+	doc   *assemble.Document
+	err   error
+	class string
+	score int
+}
+
+// FindRoot returns the document assembled from the main .tex file of a paper:
+// the top-level file of 00README.XXX, or else the complete document with
+// \documentclass and \begin{document} that no other document loads, preferring
+// names like main.tex and documents made of more files. The document is nil
+// when the paper has no root, the error holds the problems of assembling it.
+func FindRoot(paper Paper, files []string, opts assemble.Options) (*assemble.Document, error) {
+	toplevel, ignored := readReadme(paper.FS)
+	if toplevel != "" && slices.Contains(files, toplevel) {
+		if doc, err := assemble.Assemble(paper.FS, toplevel, opts); doc != nil {
+			return doc, err
+		}
+	}
+
+	var candidates, partial []rootCandidate
+	for _, file := range files {
+		if ignored[file] {
+			continue
+		}
+		content, err := fs.ReadFile(paper.FS, file)
+		if err != nil {
+			continue
+		}
+		doc, _ := latex.Parse(string(content))
+		if beginDocument(doc.Tokens) < 0 {
+			continue
+		}
+		c := rootCandidate{file: file, class: documentClass(doc)}
+		if c.class != "" {
+			candidates = append(candidates, c)
+		} else {
+			partial = append(partial, c)
+		}
+	}
+	if len(candidates) == 0 {
+		// a \documentclass loaded from another file
+		candidates = partial
+	}
+
+	for i := range candidates {
+		candidates[i].doc, candidates[i].err = assemble.Assemble(paper.FS, candidates[i].file, opts)
+	}
+	// subfiles and documents that another one loads are no root
+	loaded := make(map[string]bool)
+	for _, c := range candidates {
+		if c.doc == nil {
+			continue
+		}
+		for _, file := range c.doc.Files[1:] {
+			loaded[file] = true
+		}
+	}
+	candidates = slices.DeleteFunc(candidates, func(c rootCandidate) bool {
+		return c.doc == nil || loaded[c.file]
+	})
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	for i := range candidates {
+		candidates[i].score = rootScore(paper, &candidates[i])
+	}
+	best := slices.MaxFunc(candidates, func(a, b rootCandidate) int {
+		switch {
+		case a.score != b.score:
+			return a.score - b.score
+		case len(a.doc.Files) != len(b.doc.Files):
+			return len(a.doc.Files) - len(b.doc.Files)
+		case len(a.doc.Source) != len(b.doc.Source):
+			return len(a.doc.Source) - len(b.doc.Source)
+		}
+		// the first name wins
+		return strings.Compare(b.file, a.file)
+	})
+	return best.doc, best.err
+}
+
+// rootScore rates how likely the name and class of a candidate are those of a
+// main document.
+func rootScore(paper Paper, c *rootCandidate) int {
+	name := strings.ToLower(strings.TrimSuffix(path.Base(c.file), path.Ext(c.file)))
+	score := 0
+	if slices.Contains(rootNames, name) || name == strings.ToLower(paper.ID) {
+		score += 2
+	}
+	for _, unlikely := range unlikelyNames {
+		if strings.Contains(name, unlikely) {
+			score -= 2
+			break
+		}
+	}
+	if path.Dir(c.file) == "." {
+		score++
+	}
+	if c.class == "subfiles" || c.class == "standalone" {
+		score -= 3
+	}
+	return score
+}
+
+// documentClass returns the class of the \documentclass (or the \documentstyle
+// of LaTeX 2.09) of doc, empty if it has none.
+func documentClass(doc *latex.Document) string {
+	class := ""
+	visitCommands(doc.Nodes, []string{`\documentclass`, `\documentstyle`}, func(nodes []latex.Node, i int) int {
+		if class != "" {
+			return i
+		}
+		_, next, _ := latex.NextOptionalArg(nodes, i+1)
+		arg, next, ok := latex.NextArg(nodes, next)
+		if !ok {
+			return i
+		}
+		class = strings.TrimSpace(doc.Uncommented(arg.BodyStart, arg.BodyEnd))
+		return next
+	})
+	return class
+}
+
+// readReadme returns the top-level file and the ignored files of the
+// 00README.XXX of a paper, whose lines are "<file> <directive>".
+func readReadme(fsys fs.FS) (string, map[string]bool) {
+	ignored := make(map[string]bool)
+	content, err := fs.ReadFile(fsys, readmeFile)
+	if err != nil {
+		return "", ignored
+	}
+	toplevel := ""
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		file := path.Clean(fields[0])
+		switch fields[1] {
+		case "toplevelfile":
+			if toplevel == "" {
+				toplevel = file
+			}
+		case "ignore":
+			ignored[file] = true
+		}
+	}
+	return toplevel, ignored
+}
@@ -0,0 +1,81 @@
+package src
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"regexp"
+	"strings"
+)
+
+// SplitAssigner puts a paper into a split by hashing its canonical arXiv ID, so
+// every table and every version of a paper land in the same split, whatever
+// order the papers are read in.
+type SplitAssigner struct {
+	splits []Split
+	seed   string
+	// upper bound of every split in [0, 1)
+	bounds []float64
+}
+
+// NewSplitAssigner divides the papers by the split ratios. When no split has a
+// ratio the counts are used instead, so all splits fill up at the same pace.
+func NewSplitAssigner(splits []Split, seed string) *SplitAssigner {
+	weights := make([]float64, len(splits))
+	total := 0.0
+	for i, split := range splits {
+		weights[i] = split.Ratio
+		total += split.Ratio
+	}
+	if total == 0 {
+		for i, split := range splits {
+			weights[i] = float64(split.Count)
+			total += weights[i]
+		}
+	}
+	if total == 0 {
+		for i := range splits {
+			weights[i] = 1
+			total++
+		}
+	}
+
+	bounds := make([]float64, len(splits))
+	sum := 0.0
+	for i, weight := range weights {
+		sum += weight
+		bounds[i] = sum / total
+	}
+	return &SplitAssigner{splits: splits, seed: seed, bounds: bounds}
+}
+
+func (a *SplitAssigner) Assign(paperID string) Split {
+	sum := sha256.Sum256([]byte(a.seed + "\x00" + CanonicalPaperID(paperID)))
+	// 53 bits fit exactly into a float64
+	point := float64(binary.BigEndian.Uint64(sum[:8])>>11) / (1 << 53)
+	for i, bound := range a.bounds {
+		if point < bound {
+			return a.splits[i]
+		}
+	}
+	return a.splits[len(a.splits)-1]
+}
+
+var (
+	versionSuffix = regexp.MustCompile(`v\d+$`)
+	// old style IDs like hep-th/9901001, math.AG/0001001 or hep-th9901001 as
+	// the papers are named in the tars
+	oldStyleID = regexp.MustCompile(`^([a-z-]+)(?:\.[a-z]{2})?/?(\d{7})$`)
+)
+
+// CanonicalPaperID drops the arXiv: prefix, the version and the subject class
+// of an arXiv ID, e.g. arXiv:2001.00001v2 -> 2001.00001 and
+// math.AG/0001001v1 -> math/0001001.
+func CanonicalPaperID(paperID string) string {
+	id := strings.ToLower(strings.TrimSpace(paperID))
+	id = strings.TrimPrefix(id, "arxiv:")
+	id = versionSuffix.ReplaceAllString(id, "")
+	if match := oldStyleID.FindStringSubmatch(id); match != nil {
+		return match[1] + "/" + match[2]
+	}
+	return id
+}
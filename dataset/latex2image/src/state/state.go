@@ -0,0 +1,126 @@
+package state
+
+import (
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Store keeps the processing status of every tar, paper and table, so an
+// interrupted run resumes where it stopped and reruns do not produce duplicates.
+type Store struct {
+	db *bolt.DB
+}
+
+type Kind string
+
+const (
+	KindTar   Kind = "tars"
+	KindPaper Kind = "papers"
+	KindTable Kind = "tables"
+)
+
+type Status string
+
+const (
+	StatusExtracted Status = "extracted"
+	StatusParsed    Status = "parsed"
+	StatusCompiled  Status = "compiled"
+	StatusRendered  Status = "rendered"
+	StatusFailed    Status = "failed"
+	StatusDone      Status = "done"
+)
+
+type Record struct {
+	Status Status `json:"status"`
+	Reason string `json:"reason,omitempty"`
+	// class of a failed table and the undefined command or missing file
+	Class  string `json:"class,omitempty"`
+	Detail string `json:"detail,omitempty"`
+	Split  string `json:"split,omitempty"`
+	// png of a rendered table
+	FileName string `json:"file_name,omitempty"`
+	// number of tables queued for a parsed paper
+	Tables    int       `json:"tables,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Finished reports whether a table needs no more work.
+func (r Record) Finished() bool {
+	return r.Status == StatusRendered || r.Status == StatusFailed || r.Status == StatusDone
+}
+
+func Open(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, kind := range []Kind{KindTar, KindPaper, KindTable} {
+			if _, err := tx.CreateBucketIfNotExists([]byte(kind)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+func (s *Store) Get(kind Kind, key string) (Record, bool, error) {
+	var record Record
+	found := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket([]byte(kind)).Get([]byte(key))
+		if value == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(value, &record)
+	})
+	return record, found, err
+}
+
+// Put stores a record. Concurrent calls are batched into one transaction.
+func (s *Store) Put(kind Kind, key string, record Record) error {
+	record.UpdatedAt = time.Now()
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return s.db.Batch(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(kind)).Put([]byte(key), value)
+	})
+}
+
+func (s *Store) ForEach(kind Kind, fn func(key string, record Record) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(kind)).ForEach(func(k, v []byte) error {
+			var record Record
+			if err := json.Unmarshal(v, &record); err != nil {
+				return err
+			}
+			return fn(string(k), record)
+		})
+	})
+}
+
+// SplitCounts returns the number of rendered tables of every split.
+func (s *Store) SplitCounts() (map[string]int, error) {
+	counts := make(map[string]int)
+	err := s.ForEach(KindTable, func(key string, record Record) error {
+		if record.Status == StatusRendered {
+			counts[record.Split]++
+		}
+		return nil
+	})
+	return counts, err
+}
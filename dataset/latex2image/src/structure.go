@@ -1,6 +1,21 @@
 package src
 
+import "io/fs"
+
 type Metadata struct {
 	FileName    string `json:"file_name"`
 	GroundTruth string `json:"ground_truth"`
+	// tabular environment of the table and the float around it
+	Environment string `json:"environment,omitempty"`
+	Float       string `json:"float,omitempty"`
+	// fixes applied after pdflatex failed, like "add package booktabs"
+	Repairs []string `json:"repairs,omitempty"`
+}
+
+// Paper is one arXiv submission, either extracted on disk or held in memory.
+type Paper struct {
+	ID string
+	// name of the arXiv tar the paper was read from, empty for paper folders
+	Tar string
+	FS  fs.FS
 }
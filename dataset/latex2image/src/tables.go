@@ -0,0 +1,160 @@
+package src
+
+import (
+	"strings"
+
+	"latex2image/src/latex"
+)
+
+// texTable is a tabular found in a .tex file.
+type texTable struct {
+	// \begin{tabular}...\end{tabular} as written in the paper
+	source string
+	// tabular, tabularx, longtable, ...
+	env string
+	// innermost float around the tabular, empty for bare tabulars
+	float string
+	// offset of the tabular in the document it was found in
+	start int
+}
+
+var tabularEnvs = map[string]bool{
+	"tabular":       true,
+	"tabular*":      true,
+	"tabularx":      true,
+	"tabulary":      true,
+	"longtable":     true,
+	"supertabular":  true,
+	"supertabular*": true,
+}
+
+var floatEnvs = map[string]bool{
+	"table":          true,
+	"table*":         true,
+	"sidewaystable":  true,
+	"sidewaystable*": true,
+	"wraptable":      true,
+	"threeparttable": true,
+}
+
+// tabulars in figures lay out images, they are not tables
+var skippedEnvs = map[string]bool{
+	"figure":  true,
+	"figure*": true,
+}
+
+// extractTables returns every top-level tabular of the floats and every bare
+// tabular outside of them, so the subtables and minipages of a float each give
+// a table. A tabular nested in another tabular stays part of the outer one.
+// Problems like unclosed environments are returned with their position next to
+// the tables that could be read.
+func extractTables(content string) ([]texTable, error) {
+	doc, err := latex.Parse(content)
+	var result []texTable
+	collectTables(doc, doc.Nodes, "", &result)
+	return result, err
+}
+
+func collectTables(doc *latex.Document, nodes []latex.Node, float string, result *[]texTable) {
+	for i := range nodes {
+		n := &nodes[i]
+		switch {
+		case n.Kind != latex.EnvNode:
+			collectTables(doc, n.Children, float, result)
+		case tabularEnvs[n.Name]:
+			*result = append(*result, texTable{source: doc.Text(n), env: n.Name, float: float, start: n.Start})
+		case floatEnvs[n.Name]:
+			collectTables(doc, n.Children, n.Name, result)
+		case !skippedEnvs[n.Name]:
+			collectTables(doc, n.Children, float, result)
+		}
+	}
+}
+
+// compileSource returns the table as it is put into the standalone document.
+// standalone boxes its content, which longtable and supertabular do not allow,
+// so they become a tabular.
+func compileSource(table texTable) string {
+	doc, _ := latex.Parse(table.source)
+	if len(doc.Nodes) != 1 || doc.Nodes[0].Kind != latex.EnvNode {
+		return table.source
+	}
+	env := &doc.Nodes[0]
+	switch env.Name {
+	case "supertabular":
+		return `\begin{tabular}` + doc.Body(env) + `\end{tabular}`
+	case "supertabular*":
+		return `\begin{tabular*}` + doc.Body(env) + `\end{tabular*}`
+	case "longtable":
+		return longtableToTabular(doc, env)
+	}
+	return table.source
+}
+
+// longtableToTabular keeps the first head and the last foot of a longtable and
+// drops its caption, which is not allowed outside of floats.
+func longtableToTabular(doc *latex.Document, env *latex.Node) string {
+	nodes := env.Children
+	// alignment of the table on the page
+	_, next, _ := latex.NextOptionalArg(nodes, 0)
+	cols, next, ok := latex.NextArg(nodes, next)
+	if !ok {
+		return doc.Text(env)
+	}
+
+	parts := make(map[string]string)
+	var current strings.Builder
+	for i := next; i < len(nodes); i++ {
+		n := &nodes[i]
+		switch {
+		case n.IsCommand(`\endfirsthead`), n.IsCommand(`\endhead`), n.IsCommand(`\endfoot`), n.IsCommand(`\endlastfoot`):
+			parts[n.Token.Text] = current.String()
+			current.Reset()
+		case n.IsCommand(`\caption`):
+			j, _ := latex.NextStar(nodes, i+1)
+			_, j, _ = latex.NextOptionalArg(nodes, j)
+			_, j, _ = latex.NextArg(nodes, j)
+			if k := latex.SkipSpace(nodes, j); k < len(nodes) && nodes[k].IsCommand(`\label`) {
+				_, j, _ = latex.NextArg(nodes, k+1)
+			}
+			// the \\ ending the caption row
+			if k := latex.SkipSpace(nodes, j); k < len(nodes) && nodes[k].IsCommand(`\\`) {
+				j = k + 1
+				if _, after, ok := latex.NextOptionalArg(nodes, j); ok {
+					j = after
+				}
+			}
+			i = j - 1
+		default:
+			current.WriteString(doc.Text(n))
+		}
+	}
+
+	head, hasFirstHead := parts[`\endfirsthead`]
+	if !hasFirstHead {
+		head = parts[`\endhead`]
+	}
+	foot, hasLastFoot := parts[`\endlastfoot`]
+	if !hasLastFoot {
+		foot = parts[`\endfoot`]
+	}
+	return `\begin{tabular}` + doc.Source[cols.Start:cols.End] + head + current.String() + foot + `\end{tabular}`
+}
+
+// tableDocHead loads packages in the standalone document.
+func tableDocHead(packages []Package) string {
+	lines := make([]string, len(packages))
+	for i, pkg := range packages {
+		lines[i] = pkg.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// wrapFloat puts a tabular of a threeparttable back into one, \tnote only works
+// inside of it.
+func wrapFloat(table texTable, body string) string {
+	if table.float == "threeparttable" {
+		return "\\begin{threeparttable}\n" + body + "\n\\end{threeparttable}"
+	}
+	return body
+}